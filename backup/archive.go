@@ -0,0 +1,207 @@
+package backup
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "hash"
+    "io"
+    "os"
+    "time"
+    fp "path/filepath"
+
+    "webservice/state"
+)
+
+
+const (
+    paxName = "WEBSERVICE.name"
+    paxMime = "WEBSERVICE.mime"
+)
+
+
+type ManifestEntry struct {
+    Name        string  `json:"name"`
+    MimeType    string  `json:"mimeType"`
+    Size        int     `json:"size"`
+    Checksum    string  `json:"sha256"`
+}
+
+type Manifest struct {
+    Created         time.Time           `json:"created"`
+    Archive         string              `json:"archive"`
+    ArchiveSize     int64               `json:"archiveSize"`
+    ArchiveChecksum string              `json:"archiveSha256"`
+    Entries         []ManifestEntry     `json:"entries"`
+}
+
+
+type hashingWriter struct {
+    target  io.Writer
+    hash    hash.Hash
+    size    int64
+}
+
+func ( w *hashingWriter ) Write( p []byte ) ( int, error ) {
+    n, err := w.target.Write( p )
+    w.hash.Write( p[ :n ] )
+    w.size += int64( n )
+    return n, err
+}
+
+
+func Write( dir string, baseName string, items []state.Item, created time.Time ) ( *Manifest, error ) {
+    archiveName := baseName + ".tar.gz"
+    manifest := &Manifest{
+        Created: created.UTC(),
+        Archive: archiveName,
+        Entries: make( []ManifestEntry, 0, len( items ) ),
+    }
+
+    archivePath := fp.Join( dir, archiveName )
+    file, err := os.CreateTemp( dir, ".backup-*" )
+    if err != nil {
+        return nil, err
+    }
+    defer os.Remove( file.Name() )
+    defer file.Close()
+
+    hashing := &hashingWriter{ target: file, hash: sha256.New() }
+    compressing := gzip.NewWriter( hashing )
+    archiving := tar.NewWriter( compressing )
+
+    for index, item := range items {
+        header := &tar.Header{
+            Typeflag: tar.TypeReg,
+            Name: fmt.Sprintf( "entries/%08d", index ),
+            Mode: 0600,
+            Size: int64( len( item.Data() ) ),
+            ModTime: manifest.Created,
+            Format: tar.FormatPAX,
+            PAXRecords: map[ string ] string {
+                paxName: item.Name(),
+                paxMime: item.MimeType(),
+            },
+        }
+        if err := archiving.WriteHeader( header ); err != nil {
+            return nil, err
+        }
+        if _, err := archiving.Write( item.Data() ); err != nil {
+            return nil, err
+        }
+        manifest.Entries = append( manifest.Entries, ManifestEntry{
+            Name: item.Name(),
+            MimeType: item.MimeType(),
+            Size: len( item.Data() ),
+            Checksum: item.Checksum(),
+        })
+    }
+
+    if err := archiving.Close(); err != nil {
+        return nil, err
+    }
+    if err := compressing.Close(); err != nil {
+        return nil, err
+    }
+    if err := file.Sync(); err != nil {
+        return nil, err
+    }
+    if err := file.Close(); err != nil {
+        return nil, err
+    }
+    if err := os.Rename( file.Name(), archivePath ); err != nil {
+        return nil, err
+    }
+
+    manifest.ArchiveSize = hashing.size
+    manifest.ArchiveChecksum = hex.EncodeToString( hashing.hash.Sum( nil ) )
+
+    content, err := json.MarshalIndent( manifest, "", "  " )
+    if err != nil {
+        return nil, err
+    }
+    manifestPath := fp.Join( dir, baseName + ".manifest.json" )
+    if err := os.WriteFile( manifestPath + ".tmp", content, 0600 ); err != nil {
+        return nil, err
+    }
+    if err := os.Rename( manifestPath + ".tmp", manifestPath ); err != nil {
+        return nil, err
+    }
+    return manifest, nil
+}
+
+
+func ReadManifest( path string ) ( *Manifest, error ) {
+    content, err := os.ReadFile( path )
+    if err != nil {
+        return nil, err
+    }
+    manifest := &Manifest{}
+    if err := json.Unmarshal( content, manifest ); err != nil {
+        return nil, errors.New(
+            fmt.Sprintf( "Invalid backup manifest %s: %v", path, err ),
+        )
+    }
+    return manifest, nil
+}
+
+
+func Read( manifestPath string ) ( []state.Item, error ) {
+    manifest, err := ReadManifest( manifestPath )
+    if err != nil {
+        return nil, err
+    }
+
+    file, err := os.Open( fp.Join( fp.Dir( manifestPath ), manifest.Archive ) )
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    hashing := sha256.New()
+    decompressing, err := gzip.NewReader( io.TeeReader( file, hashing ) )
+    if err != nil {
+        return nil, err
+    }
+    archive := tar.NewReader( decompressing )
+
+    items := make( []state.Item, 0, len( manifest.Entries ) )
+    for _, entry := range manifest.Entries {
+        header, err := archive.Next()
+        if err != nil {
+            return nil, errors.New(
+                fmt.Sprintf( "Backup archive misses entry %s: %v", entry.Name, err ),
+            )
+        }
+        data, err := io.ReadAll( archive )
+        if err != nil {
+            return nil, err
+        }
+
+        item := state.NewItem( header.PAXRecords[ paxName ], header.PAXRecords[ paxMime ], data )
+        if item.Name() != entry.Name || item.MimeType() != entry.MimeType ||
+           item.Checksum() != entry.Checksum {
+            return nil, errors.New(
+                fmt.Sprintf( "Backup archive entry %s does not match the manifest", entry.Name ),
+            )
+        }
+        items = append( items, item )
+    }
+
+    if _, err := io.Copy( io.Discard, decompressing ); err != nil {
+        return nil, err
+    }
+    if _, err := io.Copy( hashing, file ); err != nil {
+        return nil, err
+    }
+    if hex.EncodeToString( hashing.Sum( nil ) ) != manifest.ArchiveChecksum {
+        return nil, errors.New(
+            fmt.Sprintf( "Backup archive %s does not match its checksum", manifest.Archive ),
+        )
+    }
+    return items, nil
+}
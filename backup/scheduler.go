@@ -0,0 +1,270 @@
+package backup
+
+import (
+    "context"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    log "log/slog"
+    fp "path/filepath"
+
+    "webservice/configuration"
+    "webservice/health"
+    "webservice/state"
+)
+
+
+const manifestSuffix = ".manifest.json"
+
+
+type Status struct {
+    Started     time.Time   `json:"started"`
+    Duration    float64     `json:"durationSeconds"`
+    Archive     string      `json:"archive,omitempty"`
+    Entries     int         `json:"entries"`
+    Size        int64       `json:"size"`
+    Error       string      `json:"error,omitempty"`
+}
+
+
+type Scheduler struct {
+    store       state.Store
+    dir         string
+    interval    time.Duration
+    keep        int
+    maxAge      time.Duration
+
+    running     sync.Mutex
+    mux         sync.Mutex
+    last        *Status
+    lastSuccess *Status
+    runs        uint64
+    failures    uint64
+
+    stop        chan struct{}
+    stopping    sync.Once
+}
+
+
+func NewScheduler( store state.Store, c *configuration.Config ) *Scheduler {
+    return &Scheduler{
+        store: store,
+        dir: c.BackupDir,
+        interval: c.BackupInterval,
+        keep: c.BackupKeep,
+        maxAge: c.BackupMaxAge,
+        stop: make( chan struct{} ),
+    }
+}
+
+
+func ( s *Scheduler ) Start() {
+    go func(){
+        ticker := time.NewTicker( s.interval )
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                if status := s.Run(); len( status.Error ) >= 1 {
+                    log.Error( "Backup failed", "dir", s.dir, "error", status.Error )
+                }
+            case <-s.stop:
+                return
+            }
+        }
+    }()
+}
+
+
+func ( s *Scheduler ) Stop() {
+    s.stopping.Do( func(){
+        close( s.stop )
+    })
+}
+
+
+func ( s *Scheduler ) Run() Status {
+    s.running.Lock()
+    defer s.running.Unlock()
+
+    started := time.Now()
+    status := Status{
+        Started: started.UTC(),
+    }
+
+    manifest, err := s.write( started )
+    status.Duration = time.Since( started ).Seconds()
+    if err != nil {
+        status.Error = err.Error()
+    } else {
+        status.Archive = manifest.Archive
+        status.Entries = len( manifest.Entries )
+        status.Size = manifest.ArchiveSize
+        if err := s.prune( started ); err != nil {
+            log.Error( "Backup pruning failed", "dir", s.dir, "error", err )
+        }
+    }
+
+    s.mux.Lock()
+    s.runs++
+    s.last = &status
+    if err != nil {
+        s.failures++
+    } else {
+        s.lastSuccess = &status
+    }
+    s.mux.Unlock()
+
+    return status
+}
+
+
+func ( s *Scheduler ) write( started time.Time ) ( *Manifest, error ) {
+    if err := os.MkdirAll( s.dir, 0700 ); err != nil {
+        return nil, err
+    }
+    items, err := state.Snapshot( context.Background(), s.store )
+    if err != nil {
+        return nil, err
+    }
+    sort.Slice( items, func( a int, b int ) bool {
+        return items[ a ].Name() < items[ b ].Name()
+    })
+
+    baseName := "backup-" + started.UTC().Format( "20060102T150405.000000000Z" )
+    return Write( s.dir, baseName, items, started )
+}
+
+
+func ( s *Scheduler ) List() ( []*Manifest, error ) {
+    paths, err := fp.Glob( fp.Join( s.dir, "backup-*" + manifestSuffix ) )
+    if err != nil {
+        return nil, err
+    }
+
+    manifests := make( []*Manifest, 0, len( paths ) )
+    for _, path := range paths {
+        manifest, err := ReadManifest( path )
+        if err != nil {
+            return nil, err
+        }
+        manifests = append( manifests, manifest )
+    }
+    sort.Slice( manifests, func( a int, b int ) bool {
+        return manifests[ a ].Created.After( manifests[ b ].Created )
+    })
+    return manifests, nil
+}
+
+
+func ( s *Scheduler ) prune( now time.Time ) error {
+    manifests, err := s.List()
+    if err != nil {
+        return err
+    }
+
+    for index, manifest := range manifests {
+        if index == 0 {
+            continue
+        }
+        tooMany := index >= s.keep
+        tooOld := s.maxAge > 0 && now.Sub( manifest.Created ) > s.maxAge
+        if !tooMany && !tooOld {
+            continue
+        }
+
+        baseName := strings.TrimSuffix( manifest.Archive, ".tar.gz" )
+        if err := os.Remove( fp.Join( s.dir, baseName + manifestSuffix ) ); err != nil {
+            return err
+        }
+        if err := os.Remove( fp.Join( s.dir, manifest.Archive ) ); err != nil && ! os.IsNotExist( err ) {
+            return err
+        }
+    }
+    return nil
+}
+
+
+func ( s *Scheduler ) Status() ( *Status, *Status ) {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+
+    return s.last, s.lastSuccess
+}
+
+
+func ( s *Scheduler ) Check() health.Check {
+    last, lastSuccess := s.Status()
+
+    check := health.Check{
+        ComponentType: "component",
+        Status: health.StatusPass,
+    }
+    switch {
+    case last == nil:
+        check.Output = "No backup taken yet"
+    case len( last.Error ) >= 1:
+        check.Status = health.StatusWarn
+        check.Output = last.Error
+    case time.Since( lastSuccess.Started ) > s.interval * 2:
+        check.Status = health.StatusWarn
+        check.Output = "Last backup is overdue"
+    }
+    if lastSuccess != nil {
+        check.ObservedValue = int64( time.Since( lastSuccess.Started ).Seconds() )
+        check.ObservedUnit = "s"
+    }
+    return check
+}
+
+
+func ( s *Scheduler ) Metrics() []state.Metric {
+    s.mux.Lock()
+    defer s.mux.Unlock()
+
+    metrics := []state.Metric{
+        {
+            Name: "backup_runs_total",
+            Help: "The number of backups attempted",
+            Type: "counter",
+            Value: float64( s.runs ),
+        },
+        {
+            Name: "backup_failures_total",
+            Help: "The number of backups failed",
+            Type: "counter",
+            Value: float64( s.failures ),
+        },
+    }
+    if s.lastSuccess != nil {
+        metrics = append( metrics, []state.Metric{
+            {
+                Name: "backup_last_success_timestamp_seconds",
+                Help: "The time the last successful backup started, in seconds since epoch",
+                Type: "gauge",
+                Value: float64( s.lastSuccess.Started.Unix() ),
+            },
+            {
+                Name: "backup_last_success_duration_seconds",
+                Help: "The duration of the last successful backup, in seconds",
+                Type: "gauge",
+                Value: s.lastSuccess.Duration,
+            },
+            {
+                Name: "backup_last_success_entries",
+                Help: "The number of state entries in the last successful backup",
+                Type: "gauge",
+                Value: float64( s.lastSuccess.Entries ),
+            },
+            {
+                Name: "backup_last_success_size_bytes",
+                Help: "The size of the last successful backup archive, in bytes",
+                Type: "gauge",
+                Value: float64( s.lastSuccess.Size ),
+            },
+        }... )
+    }
+    return metrics
+}
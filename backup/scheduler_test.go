@@ -0,0 +1,102 @@
+package backup
+
+import (
+    "context"
+    "archive/tar"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "os"
+    "testing"
+    "time"
+    fp "path/filepath"
+
+    "webservice/configuration"
+    "webservice/health"
+    "webservice/state"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func TestScheduler( t *testing.T ){
+    dir := t.TempDir()
+    store := state.NewEphemeralStore()
+    _ = store.Add( context.Background(), state.NewItem( "foo", "text/plain", []byte( "bar" ) ) )
+    _ = store.Add( context.Background(), state.NewItem( "Som!_🎵nam3", "application/octet-stream", []byte{ 1, 2, 3 } ) )
+
+    scheduler := NewScheduler( store, &configuration.Config{
+        BackupDir: dir,
+        BackupInterval: time.Hour,
+        BackupKeep: 2,
+    })
+    assert.Equal( t, health.StatusPass, scheduler.Check().Status )
+
+    for i := 0; i < 3; i++ {
+        status := scheduler.Run()
+        assert.Empty( t, status.Error )
+        assert.Equal( t, 2, status.Entries )
+    }
+
+    manifests, err := scheduler.List()
+    assert.Nil( t, err )
+    assert.Len( t, manifests, 2 )
+    archives, _ := fp.Glob( fp.Join( dir, "*.tar.gz" ) )
+    assert.Len( t, archives, 2 )
+
+    latest := manifests[ 0 ]
+    content, err := os.ReadFile( fp.Join( dir, latest.Archive ) )
+    assert.Nil( t, err )
+    sum := sha256.Sum256( content )
+    assert.Equal( t, latest.ArchiveChecksum, hex.EncodeToString( sum[:] ) )
+
+    file, _ := os.Open( fp.Join( dir, latest.Archive ) )
+    defer file.Close()
+    decompressing, err := gzip.NewReader( file )
+    assert.Nil( t, err )
+    archive := tar.NewReader( decompressing )
+    for _, entry := range latest.Entries {
+        header, err := archive.Next()
+        assert.Nil( t, err )
+        assert.Equal( t, entry.Name, header.PAXRecords[ paxName ] )
+        assert.Equal( t, entry.MimeType, header.PAXRecords[ paxMime ] )
+        data, _ := io.ReadAll( archive )
+        sum := sha256.Sum256( data )
+        assert.Equal( t, entry.Checksum, hex.EncodeToString( sum[:] ) )
+    }
+
+    assert.Equal( t, health.StatusPass, scheduler.Check().Status )
+
+    _ = store.Disconnect()
+    status := scheduler.Run()
+    assert.NotEmpty( t, status.Error )
+    assert.Equal( t, health.StatusWarn, scheduler.Check().Status )
+}
+
+
+func TestRead( t *testing.T ){
+    dir := t.TempDir()
+    items := []state.Item{
+        state.NewItem( "foo", "text/plain", []byte( "bar" ) ),
+        state.NewItem( "empty", "application/octet-stream", []byte{} ),
+    }
+    _, err := Write( dir, "backup-test", items, time.Now() )
+    assert.Nil( t, err )
+
+    read, err := Read( fp.Join( dir, "backup-test" + manifestSuffix ) )
+    assert.Nil( t, err )
+    assert.Len( t, read, 2 )
+    for i, item := range read {
+        assert.Equal( t, items[ i ].Name(), item.Name() )
+        assert.Equal( t, items[ i ].MimeType(), item.MimeType() )
+        assert.Equal( t, items[ i ].Checksum(), item.Checksum() )
+    }
+
+    archive := fp.Join( dir, "backup-test.tar.gz" )
+    content, _ := os.ReadFile( archive )
+    content[ len( content ) - 1 ] ^= 0xff
+    _ = os.WriteFile( archive, content, 0600 )
+    _, err = Read( fp.Join( dir, "backup-test" + manifestSuffix ) )
+    assert.NotNil( t, err )
+}
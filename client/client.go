@@ -0,0 +1,274 @@
+package client
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+
+type Client struct {
+    server      *url.URL
+    http        *http.Client
+    token       string
+    timeout     time.Duration
+    retries     int
+    minBackoff  time.Duration
+    maxBackoff  time.Duration
+}
+
+
+type Option func( *Client )
+
+
+func WithHTTPClient( httpClient *http.Client ) Option {
+    return func( c *Client ){
+        c.http = httpClient
+    }
+}
+
+
+func WithToken( token string ) Option {
+    return func( c *Client ){
+        c.token = token
+    }
+}
+
+
+func WithTimeout( timeout time.Duration ) Option {
+    return func( c *Client ){
+        c.timeout = timeout
+    }
+}
+
+
+func WithRetries( retries int, minBackoff time.Duration, maxBackoff time.Duration ) Option {
+    return func( c *Client ){
+        c.retries = retries
+        c.minBackoff = minBackoff
+        c.maxBackoff = maxBackoff
+    }
+}
+
+
+type requestIdKey struct{}
+
+
+// WithRequestId returns a context whose requests are sent with the given id,
+// so that they are able to be found in the logs of the server
+func WithRequestId( ctx context.Context, id string ) context.Context {
+    return context.WithValue( ctx, requestIdKey{}, id )
+}
+
+
+func RequestId( ctx context.Context ) string {
+    id, _ := ctx.Value( requestIdKey{} ).( string )
+    return id
+}
+
+
+type traceContextKey struct{}
+
+type traceContext struct {
+    parent  string
+    state   string
+}
+
+
+// WithTraceContext returns a context whose requests are sent as part of the
+// given W3C trace, so that the spans of the server join it
+func WithTraceContext( ctx context.Context, traceparent string, tracestate string ) context.Context {
+    return context.WithValue( ctx, traceContextKey{}, traceContext{ traceparent, tracestate } )
+}
+
+
+func New( server string, options ...Option ) ( *Client, error ) {
+    serverURL, err := url.Parse( strings.TrimSuffix( server, "/" ) )
+    if err != nil {
+        return nil, err
+    }
+    if serverURL.Scheme != "http" && serverURL.Scheme != "https" {
+        return nil, errors.New(
+            fmt.Sprintf( "Unsupported server URL: %s", server ),
+        )
+    }
+
+    c := &Client{
+        server: serverURL,
+        http: http.DefaultClient,
+        timeout: time.Second * 30,
+        retries: 3,
+        minBackoff: time.Millisecond * 100,
+        maxBackoff: time.Second * 2,
+    }
+    for _, option := range options {
+        option( c )
+    }
+    return c, nil
+}
+
+
+type request struct {
+    method      string
+    path        string
+    query       url.Values
+    header      http.Header
+    body        io.Reader
+    // statuses returned as response, in addition to 2xx and 304
+    accept      []int
+    // added to the timeout, for requests waiting on the server
+    wait        time.Duration
+    noRetry     bool
+}
+
+
+// cancelingBody releases the request context once a streamed body is closed
+type cancelingBody struct {
+    io.ReadCloser
+    cancel context.CancelFunc
+}
+
+func ( b *cancelingBody ) Close() error {
+    defer b.cancel()
+    return b.ReadCloser.Close()
+}
+
+
+func retryable( status int ) bool {
+    switch status {
+    case http.StatusTooManyRequests, http.StatusBadGateway,
+         http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+        return true
+    default:
+        return false
+    }
+}
+
+
+func ( c *Client ) backoff( ctx context.Context, attempt int ) error {
+    delay := c.minBackoff << attempt
+    if delay > c.maxBackoff || delay <= 0 {
+        delay = c.maxBackoff
+    }
+    timer := time.NewTimer( delay )
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+
+func ( c *Client ) send( ctx context.Context, r request ) ( *http.Response, error ) {
+    target := *c.server
+    target.Path += r.path
+    target.RawPath = ""
+    if len( r.query ) >= 1 {
+        target.RawQuery = r.query.Encode()
+    }
+
+    // a streamed body is only able to be sent again if it is able to seek
+    seeker, replayable := r.body.( io.Seeker )
+    attempts := c.retries + 1
+    if r.noRetry || r.body != nil && !replayable {
+        attempts = 1
+    }
+
+    var lastErr error
+    for attempt := 0; attempt < attempts; attempt++ {
+        if attempt >= 1 {
+            if err := c.backoff( ctx, attempt - 1 ); err != nil {
+                return nil, err
+            }
+            if replayable {
+                if _, err := seeker.Seek( 0, io.SeekStart ); err != nil {
+                    return nil, err
+                }
+            }
+        }
+
+        res, err := c.attempt( ctx, target.String(), r )
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil, err
+            }
+            lastErr = &unavailableError{ cause: err }
+            continue
+        }
+
+        switch {
+        case res.StatusCode < 400:
+            return res, nil
+        case accepted( r.accept, res.StatusCode ):
+            return res, nil
+        }
+
+        lastErr = newStatusError( res )
+        if !retryable( res.StatusCode ) {
+            return nil, lastErr
+        }
+    }
+    return nil, lastErr
+}
+
+
+func accepted( statuses []int, status int ) bool {
+    for _, candidate := range statuses {
+        if candidate == status {
+            return true
+        }
+    }
+    return false
+}
+
+
+func ( c *Client ) attempt( ctx context.Context, target string, r request ) ( *http.Response, error ) {
+    cancel := context.CancelFunc( func(){} )
+    if c.timeout > 0 {
+        ctx, cancel = context.WithTimeout( ctx, c.timeout + r.wait )
+    }
+
+    body := r.body
+    if body != nil {
+        // keep the transport from closing a body which is sent again
+        body = io.NopCloser( body )
+    }
+    req, err := http.NewRequestWithContext( ctx, r.method, target, body )
+    if err != nil {
+        cancel()
+        return nil, err
+    }
+    if sized, ok := r.body.( interface{ Len() int } ); ok {
+        req.ContentLength = int64( sized.Len() )
+    }
+    for key, values := range r.header {
+        req.Header[ key ] = values
+    }
+    if len( c.token ) >= 1 {
+        req.Header.Set( "Authorization", "Bearer " + c.token )
+    }
+    if id := RequestId( ctx ); len( id ) >= 1 {
+        req.Header.Set( "X-Request-ID", id )
+    }
+    if trace, ok := ctx.Value( traceContextKey{} ).( traceContext ); ok {
+        req.Header.Set( "traceparent", trace.parent )
+        if len( trace.state ) >= 1 {
+            req.Header.Set( "tracestate", trace.state )
+        }
+    }
+
+    res, err := c.http.Do( req )
+    if err != nil {
+        cancel()
+        return nil, err
+    }
+    res.Body = &cancelingBody{ ReadCloser: res.Body, cancel: cancel }
+    return res, nil
+}
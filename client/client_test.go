@@ -0,0 +1,280 @@
+package client
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "go/parser"
+    "go/token"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+    ht "net/http/httptest"
+    fp "path/filepath"
+
+    f "github.com/gofiber/fiber/v2"
+    "github.com/gofiber/fiber/v2/middleware/adaptor"
+    "github.com/stretchr/testify/assert"
+
+    "webservice/backup"
+    "webservice/configuration"
+    "webservice/health"
+    "webservice/metrics"
+    "webservice/routing"
+    "webservice/state"
+    "webservice/tracing"
+)
+
+
+func setup( t *testing.T ) ( http.Handler, *health.Lifecycle ) {
+    os.Setenv( "ENV_NAME", "testing" )
+    t.Setenv( "BACKUP_DIR", t.TempDir() )
+    config, err := configuration.New()
+    assert.Nil( t, err )
+
+    server := f.New( f.Config{
+        BodyLimit: configuration.BODY_SIZE_LIMIT,
+    })
+    store := state.NewEphemeralStore()
+    lifecycle := health.NewLifecycle()
+    _ = lifecycle.Ready()
+    checker := health.NewChecker( config.HealthCheckInterval )
+    checker.Register(
+        "store:responseTime",
+        health.StoreProbe( store, "ephemeral", config.HealthWarnLatency ),
+    )
+    checker.Run()
+    backups := backup.NewScheduler( store, config )
+    registry := metrics.New()
+    registry.RegisterStore( store )
+    registry.Register( backups )
+    assert.Nil( t, routing.SetRoutes( server, config, store, lifecycle, checker, backups, registry, tracing.NewTracer( config ) ) )
+
+    return adaptor.FiberApp( server ), lifecycle
+}
+
+
+func newTestClient( t *testing.T, handler http.Handler ) *Client {
+    server := ht.NewServer( handler )
+    t.Cleanup( server.Close )
+
+    c, err := New( server.URL, WithRetries( 2, time.Millisecond, time.Millisecond * 5 ) )
+    assert.Nil( t, err )
+    return c
+}
+
+
+func TestState( t *testing.T ){
+    handler, _ := setup( t )
+    c := newTestClient( t, handler )
+    ctx := context.Background()
+
+    _, err := c.Get( ctx, "missing" )
+    assert.ErrorIs( t, err, ErrNotFound )
+    _, err = c.Get( WithRequestId( ctx, "test-request" ), "missing" )
+    var statusErr *StatusError
+    assert.ErrorAs( t, err, &statusErr )
+    assert.Equal( t, "test-request", statusErr.RequestId )
+    assert.Contains( t, err.Error(), "(request test-request)" )
+
+    etag, err := c.Put( ctx, "greeting", "text/plain", strings.NewReader( "hello" ), IfAbsent() )
+    assert.Nil( t, err )
+    assert.NotEmpty( t, etag )
+
+    _, err = c.Put( ctx, "greeting", "text/plain", strings.NewReader( "again" ), IfAbsent() )
+    assert.ErrorIs( t, err, ErrConflict )
+
+    info, err := c.Head( ctx, "greeting" )
+    assert.Nil( t, err )
+    assert.Equal( t, "text/plain", info.MimeType )
+    assert.Equal( t, int64( 5 ), info.Size )
+    assert.Equal( t, etag, info.ETag )
+
+    entry, err := c.Get( ctx, "greeting" )
+    assert.Nil( t, err )
+    content, err := io.ReadAll( entry.Body )
+    assert.Nil( t, err )
+    assert.Nil( t, entry.Body.Close() )
+    assert.Equal( t, "hello", string( content ) )
+    assert.Equal( t, etag, entry.ETag )
+
+    allowed, err := c.Allowed( ctx, "greeting" )
+    assert.Nil( t, err )
+    assert.Contains( t, allowed, http.MethodPut )
+
+    // a reader which is not able to seek is streamed without retries
+    streamed := io.MultiReader( bytes.NewReader( []byte( "streamed " ) ), strings.NewReader( "body" ) )
+    _, err = c.Put( ctx, "stream", "text/plain", streamed )
+    assert.Nil( t, err )
+
+    names, err := c.List( ctx )
+    assert.Nil( t, err )
+    assert.ElementsMatch( t, []string{ "greeting", "stream" }, names )
+
+    assert.ErrorIs( t, c.Remove( ctx, "greeting", IfMatch( `"outdated"` ) ), ErrConflict )
+    assert.Nil( t, c.Remove( ctx, "greeting", IfMatch( etag ) ) )
+    assert.ErrorIs( t, c.Remove( ctx, "greeting" ), ErrNotFound )
+}
+
+
+func TestWait( t *testing.T ){
+    handler, _ := setup( t )
+    c := newTestClient( t, handler )
+    ctx := context.Background()
+
+    etag, err := c.Put( ctx, "watched", "text/plain", strings.NewReader( "first" ) )
+    assert.Nil( t, err )
+
+    _, changed, err := c.Wait( ctx, "watched", etag, time.Millisecond * 50 )
+    assert.Nil( t, err )
+    assert.False( t, changed )
+
+    go func(){
+        time.Sleep( time.Millisecond * 50 )
+        _, _ = c.Put( ctx, "watched", "text/plain", strings.NewReader( "second" ) )
+    }()
+    entry, changed, err := c.Wait( ctx, "watched", etag, time.Second * 5 )
+    assert.Nil( t, err )
+    assert.True( t, changed )
+    content, _ := io.ReadAll( entry.Body )
+    entry.Body.Close()
+    assert.Equal( t, "second", string( content ) )
+    assert.NotEqual( t, etag, entry.ETag )
+}
+
+
+func TestService( t *testing.T ){
+    handler, lifecycle := setup( t )
+    c := newTestClient( t, handler )
+    ctx := context.Background()
+
+    greeting, err := c.Index( ctx )
+    assert.Nil( t, err )
+    assert.Equal( t, "Hello, World!", greeting )
+
+    report, err := c.Health( ctx )
+    assert.Nil( t, err )
+    assert.Equal( t, StatusPass, report.Status )
+    assert.Contains( t, report.Checks, "store:responseTime" )
+    assert.Equal( t, StatusPass, report.Checks[ "store:responseTime" ][ 0 ].Status )
+
+    phase, err := c.Ready( ctx )
+    assert.Nil( t, err )
+    assert.Equal( t, "ready", phase )
+
+    metrics, err := c.Metrics( ctx )
+    assert.Nil( t, err )
+    assert.Contains( t, metrics, "backup_runs_total" )
+
+    env, err := c.Env( ctx )
+    assert.Nil( t, err )
+    assert.Contains( t, env, "ENV_NAME=testing" )
+
+    status, err := c.Backup( ctx )
+    assert.Nil( t, err )
+    assert.Empty( t, status.Error )
+    backups, err := c.Backups( ctx )
+    assert.Nil( t, err )
+    assert.Len( t, backups.Backups, 1 )
+    assert.Equal( t, status.Archive, backups.LastSuccess.Archive )
+    assert.Equal( t, status.Archive, backups.Backups[ 0 ].Archive )
+    assert.Len( t, backups.Backups[ 0 ].Entries, status.Entries )
+
+    _ = lifecycle.Drain()
+    report, err = c.Health( ctx )
+    assert.ErrorIs( t, err, ErrUnavailable )
+    assert.Equal( t, StatusFail, report.Status )
+    _, err = c.Ready( ctx )
+    assert.ErrorIs( t, err, ErrUnavailable )
+}
+
+
+func TestRetries( t *testing.T ){
+    handler, _ := setup( t )
+    var requests atomic.Int32
+    flaky := http.HandlerFunc( func( w http.ResponseWriter, r *http.Request ){
+        if requests.Add( 1 ) <= 2 {
+            w.WriteHeader( http.StatusServiceUnavailable )
+            return
+        }
+        handler.ServeHTTP( w, r )
+    })
+    c := newTestClient( t, flaky )
+    ctx := context.Background()
+
+    _, err := c.Put( ctx, "retried", "text/plain", strings.NewReader( "replayed body" ) )
+    assert.Nil( t, err )
+    assert.Equal( t, int32( 3 ), requests.Load() )
+
+    entry, err := c.Get( ctx, "retried" )
+    assert.Nil( t, err )
+    content, _ := io.ReadAll( entry.Body )
+    entry.Body.Close()
+    assert.Equal( t, "replayed body", string( content ) )
+
+    requests.Store( 0 )
+    _, err = c.Put( ctx, "retried", "text/plain", io.MultiReader( strings.NewReader( "once" ) ) )
+    assert.ErrorIs( t, err, ErrUnavailable )
+    assert.Equal( t, int32( 1 ), requests.Load() )
+
+    requests.Store( -10 )
+    _, err = c.List( ctx )
+    assert.ErrorIs( t, err, ErrUnavailable )
+    assert.Equal( t, int32( -7 ), requests.Load() )
+
+    unreachable, err := New( "http://127.0.0.1:1", WithRetries( 1, time.Millisecond, time.Millisecond ) )
+    assert.Nil( t, err )
+    _, err = unreachable.List( ctx )
+    assert.ErrorIs( t, err, ErrUnavailable )
+
+    canceled, cancel := context.WithCancel( ctx )
+    cancel()
+    _, err = c.List( canceled )
+    assert.True( t, errors.Is( err, context.Canceled ) )
+}
+
+
+func TestTraceContext( t *testing.T ){
+    var received http.Header
+    c := newTestClient( t, http.HandlerFunc( func( w http.ResponseWriter, req *http.Request ){
+        received = req.Header.Clone()
+        w.WriteHeader( http.StatusNotFound )
+    }))
+
+    ctx := WithTraceContext(
+        context.Background(),
+        "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+        "vendor=value",
+    )
+    _, err := c.Get( ctx, "missing" )
+    assert.ErrorIs( t, err, ErrNotFound )
+    assert.Equal( t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", received.Get( "traceparent" ) )
+    assert.Equal( t, "vendor=value", received.Get( "tracestate" ) )
+
+    _, err = c.Get( context.Background(), "missing" )
+    assert.ErrorIs( t, err, ErrNotFound )
+    assert.Equal( t, "", received.Get( "traceparent" ) )
+}
+
+
+// TestDependencies keeps the client importable without pulling in the
+// packages of the service or any module beyond the standard library
+func TestDependencies( t *testing.T ){
+    files, err := fp.Glob( "*.go" )
+    assert.Nil( t, err )
+    for _, file := range files {
+        if strings.HasSuffix( file, "_test.go" ) {
+            continue
+        }
+        parsed, err := parser.ParseFile( token.NewFileSet(), file, nil, parser.ImportsOnly )
+        assert.Nil( t, err )
+        for _, spec := range parsed.Imports {
+            module, _, _ := strings.Cut( strings.Trim( spec.Path.Value, `"` ), "/" )
+            assert.False( t, module == "webservice" || strings.Contains( module, "." ), file, spec.Path.Value )
+        }
+    }
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "strings"
+)
+
+
+var (
+    ErrNotFound     = errors.New( "Not found" )
+    ErrConflict     = errors.New( "Conflict" )
+    ErrUnavailable  = errors.New( "Unavailable" )
+)
+
+
+type StatusError struct {
+    StatusCode  int
+    Message     string
+    RequestId   string
+}
+
+
+func newStatusError( res *http.Response ) *StatusError {
+    defer res.Body.Close()
+
+    content, _ := io.ReadAll( io.LimitReader( res.Body, 1024 ) )
+    return &StatusError{
+        StatusCode: res.StatusCode,
+        Message: strings.TrimSpace( string( content ) ),
+        RequestId: res.Header.Get( "X-Request-ID" ),
+    }
+}
+
+
+func ( e *StatusError ) Error() string {
+    message := fmt.Sprintf( "Server responded with %d %s", e.StatusCode, http.StatusText( e.StatusCode ) )
+    if len( e.Message ) >= 1 && e.Message != http.StatusText( e.StatusCode ) {
+        message += ": " + e.Message
+    }
+    if len( e.RequestId ) >= 1 {
+        message += fmt.Sprintf( " (request %s)", e.RequestId )
+    }
+    return message
+}
+
+
+func ( e *StatusError ) Is( target error ) bool {
+    switch target {
+    case ErrNotFound:
+        return e.StatusCode == http.StatusNotFound
+    case ErrConflict:
+        return e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusPreconditionFailed
+    case ErrUnavailable:
+        return e.StatusCode == http.StatusBadGateway ||
+               e.StatusCode == http.StatusServiceUnavailable ||
+               e.StatusCode == http.StatusGatewayTimeout
+    default:
+        return false
+    }
+}
+
+
+type unavailableError struct {
+    cause error
+}
+
+
+func ( e *unavailableError ) Error() string {
+    return fmt.Sprintf( "Server not reachable: %v", e.cause )
+}
+
+
+func ( e *unavailableError ) Is( target error ) bool {
+    return target == ErrUnavailable
+}
+
+
+func ( e *unavailableError ) Unwrap() error {
+    return e.cause
+}
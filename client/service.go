@@ -0,0 +1,212 @@
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "strings"
+    "time"
+)
+
+
+// The types below mirror the JSON responses of the service, they are
+// declared here so that the client does not depend on the service's packages
+
+
+const (
+    StatusPass = "pass"
+    StatusWarn = "warn"
+    StatusFail = "fail"
+)
+
+
+type Health struct {
+    Status      string                      `json:"status"`
+    Version     string                      `json:"version,omitempty"`
+    ReleaseId   string                      `json:"releaseId,omitempty"`
+    ServiceId   string                      `json:"serviceId,omitempty"`
+    Output      string                      `json:"output,omitempty"`
+    Checks      map[ string ] []Check       `json:"checks,omitempty"`
+}
+
+
+type Check struct {
+    ComponentId     string  `json:"componentId,omitempty"`
+    ComponentType   string  `json:"componentType,omitempty"`
+    ObservedValue   any     `json:"observedValue,omitempty"`
+    ObservedUnit    string  `json:"observedUnit,omitempty"`
+    Status          string  `json:"status"`
+    Time            string  `json:"time,omitempty"`
+    Output          string  `json:"output,omitempty"`
+}
+
+
+// Status is the outcome of a backup
+type Status struct {
+    Started     time.Time   `json:"started"`
+    Duration    float64     `json:"durationSeconds"`
+    Archive     string      `json:"archive,omitempty"`
+    Entries     int         `json:"entries"`
+    Size        int64       `json:"size"`
+    Error       string      `json:"error,omitempty"`
+}
+
+
+type ManifestEntry struct {
+    Name        string  `json:"name"`
+    MimeType    string  `json:"mimeType"`
+    Size        int     `json:"size"`
+    Checksum    string  `json:"sha256"`
+}
+
+
+type Manifest struct {
+    Created         time.Time           `json:"created"`
+    Archive         string              `json:"archive"`
+    ArchiveSize     int64               `json:"archiveSize"`
+    ArchiveChecksum string              `json:"archiveSha256"`
+    Entries         []ManifestEntry     `json:"entries"`
+}
+
+
+type Backups struct {
+    Last        *Status         `json:"last"`
+    LastSuccess *Status         `json:"lastSuccess"`
+    Backups     []*Manifest     `json:"backups"`
+}
+
+
+func ( c *Client ) text( ctx context.Context, r request ) ( string, error ) {
+    res, err := c.send( ctx, r )
+    if err != nil {
+        return "", err
+    }
+    defer res.Body.Close()
+
+    content, err := io.ReadAll( res.Body )
+    return string( content ), err
+}
+
+
+func ( c *Client ) Index( ctx context.Context ) ( string, error ) {
+    return c.text( ctx, request{
+        method: http.MethodGet,
+        path: "/",
+    })
+}
+
+
+// Health returns the reported health along with an error matching
+// ErrUnavailable if the service reports to fail
+func ( c *Client ) Health( ctx context.Context ) ( *Health, error ) {
+    res, err := c.send( ctx, request{
+        method: http.MethodGet,
+        path: "/health",
+        accept: []int{ http.StatusServiceUnavailable },
+        noRetry: true,
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    report := &Health{}
+    if err := json.NewDecoder( res.Body ).Decode( report ); err != nil {
+        return nil, err
+    }
+    if res.StatusCode == http.StatusServiceUnavailable {
+        return report, &StatusError{ StatusCode: res.StatusCode, Message: report.Output }
+    }
+    return report, nil
+}
+
+
+func ( c *Client ) probe( ctx context.Context, path string ) ( string, error ) {
+    phase, err := c.text( ctx, request{
+        method: http.MethodGet,
+        path: path,
+        noRetry: true,
+    })
+    return strings.TrimSpace( phase ), err
+}
+
+
+// Live, Ready and Started return the life cycle phase of the service
+// or an error matching ErrUnavailable if the probe does not pass
+func ( c *Client ) Live( ctx context.Context ) ( string, error ) {
+    return c.probe( ctx, "/livez" )
+}
+
+
+func ( c *Client ) Ready( ctx context.Context ) ( string, error ) {
+    return c.probe( ctx, "/readyz" )
+}
+
+
+func ( c *Client ) Started( ctx context.Context ) ( string, error ) {
+    return c.probe( ctx, "/startupz" )
+}
+
+
+func ( c *Client ) Metrics( ctx context.Context ) ( string, error ) {
+    return c.text( ctx, request{
+        method: http.MethodGet,
+        path: "/metrics",
+    })
+}
+
+
+func ( c *Client ) Env( ctx context.Context ) ( []string, error ) {
+    content, err := c.text( ctx, request{
+        method: http.MethodGet,
+        path: "/env",
+    })
+    if err != nil {
+        return nil, err
+    }
+    return strings.Split( strings.TrimSuffix( content, "\n" ), "\n" ), nil
+}
+
+
+func ( c *Client ) Backups( ctx context.Context ) ( *Backups, error ) {
+    res, err := c.send( ctx, request{
+        method: http.MethodGet,
+        path: "/admin/backups",
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    backups := &Backups{}
+    if err := json.NewDecoder( res.Body ).Decode( backups ); err != nil {
+        return nil, err
+    }
+    return backups, nil
+}
+
+
+// Backup takes a backup right away, a failed backup is returned
+// along with an error
+func ( c *Client ) Backup( ctx context.Context ) ( *Status, error ) {
+    res, err := c.send( ctx, request{
+        method: http.MethodPost,
+        path: "/admin/backups",
+        accept: []int{ http.StatusInternalServerError },
+        noRetry: true,
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    status := &Status{}
+    if err := json.NewDecoder( res.Body ).Decode( status ); err != nil {
+        return nil, err
+    }
+    if res.StatusCode == http.StatusInternalServerError {
+        return status, &StatusError{ StatusCode: res.StatusCode, Message: status.Error }
+    }
+    return status, nil
+}
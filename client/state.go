@@ -0,0 +1,211 @@
+package client
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/url"
+    "strings"
+    "time"
+)
+
+
+const statePathPrefix = "/state/"
+
+
+type Info struct {
+    Name        string
+    MimeType    string
+    ETag        string
+    Size        int64
+}
+
+
+// Entry streams the data of an entry, the Body has to be closed
+type Entry struct {
+    Info
+    Body        io.ReadCloser
+}
+
+
+type Precondition func( http.Header )
+
+
+func IfMatch( etag string ) Precondition {
+    return func( header http.Header ){
+        header.Set( "If-Match", etag )
+    }
+}
+
+
+func IfNoneMatch( etag string ) Precondition {
+    return func( header http.Header ){
+        header.Set( "If-None-Match", etag )
+    }
+}
+
+
+func IfAbsent() Precondition {
+    return IfNoneMatch( "*" )
+}
+
+
+func newInfo( name string, res *http.Response ) Info {
+    return Info{
+        Name: name,
+        MimeType: res.Header.Get( "Content-Type" ),
+        ETag: res.Header.Get( "ETag" ),
+        Size: res.ContentLength,
+    }
+}
+
+
+func ( c *Client ) Get( ctx context.Context, name string ) ( *Entry, error ) {
+    res, err := c.send( ctx, request{
+        method: http.MethodGet,
+        path: statePathPrefix + name,
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &Entry{ Info: newInfo( name, res ), Body: res.Body }, nil
+}
+
+
+// Wait blocks until the entry's ETag differs from after or wait elapses,
+// in which case changed is false and no entry is returned
+func ( c *Client ) Wait(
+    ctx context.Context,
+    name string,
+    after string,
+    wait time.Duration,
+) ( entry *Entry, changed bool, err error ) {
+    query := url.Values{}
+    query.Set( "wait", wait.String() )
+    if len( after ) >= 1 {
+        query.Set( "after", after )
+    }
+
+    res, err := c.send( ctx, request{
+        method: http.MethodGet,
+        path: statePathPrefix + name,
+        query: query,
+        wait: wait,
+    })
+    if err != nil {
+        return nil, false, err
+    }
+    if res.StatusCode == http.StatusNotModified {
+        res.Body.Close()
+        return nil, false, nil
+    }
+    return &Entry{ Info: newInfo( name, res ), Body: res.Body }, true, nil
+}
+
+
+func ( c *Client ) Head( ctx context.Context, name string ) ( *Info, error ) {
+    res, err := c.send( ctx, request{
+        method: http.MethodHead,
+        path: statePathPrefix + name,
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    info := newInfo( name, res )
+    return &info, nil
+}
+
+
+// Put streams the body to the entry and returns its new ETag,
+// retries are only possible if the body is able to seek
+func ( c *Client ) Put(
+    ctx context.Context,
+    name string,
+    mimeType string,
+    body io.Reader,
+    preconditions ...Precondition,
+) ( string, error ) {
+    header := http.Header{}
+    header.Set( "Content-Type", mimeType )
+    for _, precondition := range preconditions {
+        precondition( header )
+    }
+
+    res, err := c.send( ctx, request{
+        method: http.MethodPut,
+        path: statePathPrefix + name,
+        header: header,
+        body: body,
+    })
+    if err != nil {
+        return "", err
+    }
+    defer res.Body.Close()
+
+    return res.Header.Get( "ETag" ), nil
+}
+
+
+func ( c *Client ) Remove( ctx context.Context, name string, preconditions ...Precondition ) error {
+    header := http.Header{}
+    for _, precondition := range preconditions {
+        precondition( header )
+    }
+
+    res, err := c.send( ctx, request{
+        method: http.MethodDelete,
+        path: statePathPrefix + name,
+        header: header,
+    })
+    if err != nil {
+        return err
+    }
+    return res.Body.Close()
+}
+
+
+func ( c *Client ) Allowed( ctx context.Context, name string ) ( []string, error ) {
+    res, err := c.send( ctx, request{
+        method: http.MethodOptions,
+        path: statePathPrefix + name,
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    methods := strings.Split( res.Header.Get( "Allow" ), "," )
+    for i, method := range methods {
+        methods[ i ] = strings.TrimSpace( method )
+    }
+    return methods, nil
+}
+
+
+func ( c *Client ) List( ctx context.Context ) ( []string, error ) {
+    header := http.Header{}
+    header.Set( "Accept", "application/json" )
+
+    res, err := c.send( ctx, request{
+        method: http.MethodGet,
+        path: "/states",
+        header: header,
+    })
+    if err != nil {
+        return nil, err
+    }
+    defer res.Body.Close()
+
+    var paths []string
+    if err := json.NewDecoder( res.Body ).Decode( &paths ); err != nil {
+        return nil, err
+    }
+    names := make( []string, len( paths ) )
+    for i, path := range paths {
+        names[ i ] = strings.TrimPrefix( path, statePathPrefix )
+    }
+    return names, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+
+    "webservice/configuration"
+
+    "gopkg.in/yaml.v3"
+)
+
+
+func runConfig( args []string ) int {
+    flags := flag.NewFlagSet( "config", flag.ContinueOnError )
+    flags.Usage = func(){
+        fmt.Fprintln( flags.Output(), "Usage: artifact.bin config print [--format env|json|yaml] [options]" )
+        fmt.Fprintln( flags.Output(), "" )
+        fmt.Fprintln( flags.Output(), "Prints the effective configuration merged from defaults, the configuration" )
+        fmt.Fprintln( flags.Output(), "file, the environment and flags, with secrets redacted." )
+        fmt.Fprintln( flags.Output(), "" )
+        flags.PrintDefaults()
+    }
+    format := flags.String( "format", "env", "output format: env, json or yaml" )
+    sources := configuration.BindFlags( flags )
+
+    if len( args ) <= 0 || args[ 0 ] != "print" {
+        flags.Usage()
+        return 2
+    }
+    if err := flags.Parse( args[ 1: ] ); err != nil {
+        if errors.Is( err, flag.ErrHelp ) {
+            return 0
+        }
+        return 2
+    }
+    if flags.NArg() >= 1 {
+        flags.Usage()
+        return 2
+    }
+
+    config, err := sources.Load()
+    if err != nil {
+        fmt.Fprintf( os.Stderr, "Configuration invalid: %v\n", err )
+        return 1
+    }
+    settings := config.Settings()
+
+    switch *format {
+    case "env":
+        for _, setting := range settings {
+            fmt.Printf( "%s=%s\n", setting.Key, setting.Value )
+        }
+    case "json":
+        encoder := json.NewEncoder( os.Stdout )
+        encoder.SetIndent( "", "  " )
+        err = encoder.Encode( settings )
+    case "yaml":
+        err = yaml.NewEncoder( os.Stdout ).Encode( settings )
+    default:
+        fmt.Fprintf( os.Stderr, "Unsupported format: %s\n", *format )
+        return 2
+    }
+    if err != nil {
+        fmt.Fprintln( os.Stderr, err )
+        return 1
+    }
+    return 0
+}
@@ -1,18 +1,29 @@
 package configuration
 
 import (
+    "crypto/tls"
     "errors"
     "fmt"
     "os"
+    "net"
+    "net/url"
+    "path"
+    "strconv"
+    "strings"
+    "text/template"
     "log/slog"
     "unicode"
+    "time"
     fp "path/filepath"
 
     configParser "github.com/caarlos0/env/v9"
+    "github.com/go-playground/validator/v10"
+    db "github.com/redis/go-redis/v9"
 )
 
 
 const BODY_SIZE_LIMIT = 32 * 1024 * 1024    // 32 MB, in bytes
+const STATE_WAIT_LIMIT = time.Minute * 5
 
 var version string = "n/a"
 
@@ -22,27 +33,129 @@ type Config struct {
 
     FontColor   string `env:"FONT_COLOR"  envDefault:""`
 
-    LogLevel    string `env:"LOG_LEVE"  envDefault:"error"`
+    LogLevel    string `env:"LOG_LEVEL"   envDefault:""`
+    LogFormat   string `env:"LOG_FORMAT"  envDefault:"text"  validate:"oneof=text json"`
+    LogFile     string `env:"LOG_FILE"    envDefault:""`
+
+    // Deprecated: misspelled predecessor of LOG_LEVEL, only used if LOG_LEVEL is not set
+    LogLevelLegacy  string `env:"LOG_LEVE"  envDefault:""`
+
+    AccessLog               string          `env:"ACCESS_LOG"              envDefault:""`
+    AccessLogFormat         string          `env:"ACCESS_LOG_FORMAT"       envDefault:"combined"    validate:"required"`
+    AccessLogMaxSize        int64           `env:"ACCESS_LOG_MAX_SIZE"     envDefault:"104857600"   validate:"gte=0"`
+    AccessLogMaxAge         time.Duration   `env:"ACCESS_LOG_MAX_AGE"      envDefault:"24h"         validate:"gte=0"`
+    AccessLogMaxBackups     int             `env:"ACCESS_LOG_MAX_BACKUPS"  envDefault:"7"           validate:"gte=0"`
+    AccessLogSkipProbes     bool            `env:"ACCESS_LOG_SKIP_PROBES"  envDefault:"false"`
+
+    ServiceId       string `env:"SERVICE_ID"  envDefault:"webservice"`
+    ReleaseId       string `env:"RELEASE_ID"  envDefault:""`
+
+    HealthCheckInterval     time.Duration   `env:"HEALTH_CHECK_INTERVAL"  envDefault:"10s"    validate:"gt=0"`
+    HealthWarnLatency       time.Duration   `env:"HEALTH_WARN_LATENCY"    envDefault:"250ms"  validate:"gt=0"`
+
+    PreStopDelay            time.Duration   `env:"PRE_STOP_DELAY"         envDefault:"0s"     validate:"gte=0"`
 
     Environment     string `env:"ENV_NAME"  envDefault:"development"`
     Host            string `env:"HOST"      envDefault:"127.0.0.1"`
     Port            int16  `env:"PORT"      envDefault:"3000"`
 
+    DatabaseURL         string `env:"DB_URL"        envDefault:""  redact:"url"`
     DatabaseHost        string `env:"DB_HOST"       envDefault:""`
     DatabasePort        int16  `env:"DB_PORT"       envDefault:"6379"`
     DatabaseName        int    `env:"DB_NAME"       envDefault:"0"`
     DatabaseUsername    string `env:"DB_USERNAME"   envDefault:""`
-    DatabasePassword    string `env:"DB_PASSWORD"   envDefault:""`
+    DatabasePassword    string `env:"DB_PASSWORD"   envDefault:""  redact:"true"`
+
+    DatabaseSentinelMaster      string      `env:"DB_SENTINEL_MASTER"    envDefault:""`
+    DatabaseSentinelAddresses   []string    `env:"DB_SENTINEL_ADDRS"     envSeparator:","`
+    DatabaseSentinelUsername    string      `env:"DB_SENTINEL_USERNAME"  envDefault:""`
+    DatabaseSentinelPassword    string      `env:"DB_SENTINEL_PASSWORD"  envDefault:""  redact:"true"`
+
+    DatabaseClusterAddresses    []string    `env:"DB_CLUSTER_ADDRS"      envSeparator:","`
+
+    DatabaseTLS             bool    `env:"DB_TLS"                envDefault:"false"`
+    DatabaseTLSCAFile       string  `env:"DB_TLS_CA_FILE"        envDefault:""`
+    DatabaseTLSCertFile     string  `env:"DB_TLS_CERT_FILE"      envDefault:""`
+    DatabaseTLSKeyFile      string  `env:"DB_TLS_KEY_FILE"       envDefault:""`
+    DatabaseTLSServerName   string  `env:"DB_TLS_SERVER_NAME"    envDefault:""`
+    DatabaseTLSMinVersion   string  `env:"DB_TLS_MIN_VERSION"    envDefault:"1.2"`
+
+    DatabaseOperationTimeout    time.Duration   `env:"DB_OPERATION_TIMEOUT"   envDefault:"20s"  validate:"gt=0"`
+    DatabaseDialTimeout         time.Duration   `env:"DB_DIAL_TIMEOUT"        envDefault:"3s"   validate:"gt=0"`
+    DatabaseReadTimeout         time.Duration   `env:"DB_READ_TIMEOUT"        envDefault:"3s"   validate:"gte=0"`
+    DatabaseWriteTimeout        time.Duration   `env:"DB_WRITE_TIMEOUT"       envDefault:"3s"   validate:"gte=0"`
+    DatabaseMaxRetries          int             `env:"DB_MAX_RETRIES"         envDefault:"3"    validate:"gte=-1"`
+    DatabaseMinRetryBackoff     time.Duration   `env:"DB_MIN_RETRY_BACKOFF"   envDefault:"1s"   validate:"gte=0"`
+    DatabaseMaxRetryBackoff     time.Duration   `env:"DB_MAX_RETRY_BACKOFF"   envDefault:"2s"   validate:"gtefield=DatabaseMinRetryBackoff"`
+    DatabasePoolSize            int             `env:"DB_POOL_SIZE"           envDefault:"0"    validate:"gte=0"`
+    DatabasePoolTimeout         time.Duration   `env:"DB_POOL_TIMEOUT"        envDefault:"0s"   validate:"gte=0"`
+    DatabaseMinIdleConns        int             `env:"DB_MIN_IDLE_CONNS"      envDefault:"0"    validate:"gte=0"`
+    DatabaseMaxIdleConns        int             `env:"DB_MAX_IDLE_CONNS"      envDefault:"0"    validate:"gte=0"`
+    DatabaseMaxActiveConns      int             `env:"DB_MAX_ACTIVE_CONNS"    envDefault:"0"    validate:"gte=0"`
+    DatabaseConnMaxIdleTime     time.Duration   `env:"DB_CONN_MAX_IDLE_TIME"  envDefault:"30m"  validate:"gte=0"`
+    DatabaseConnMaxLifetime     time.Duration   `env:"DB_CONN_MAX_LIFETIME"   envDefault:"0s"   validate:"gte=0"`
+    DatabaseMaxRedirects        int             `env:"DB_MAX_REDIRECTS"       envDefault:"3"    validate:"gte=0"`
+
+    DatabaseStartupTimeout      time.Duration   `env:"DB_STARTUP_TIMEOUT"     envDefault:"30s"    validate:"gt=0"`
+    DatabaseStartupBackoff      time.Duration   `env:"DB_STARTUP_BACKOFF"     envDefault:"500ms"  validate:"gt=0"`
+    DatabaseStartupDegraded     bool            `env:"DB_STARTUP_DEGRADED"    envDefault:"false"`
+
+    CacheEnabled        bool            `env:"CACHE_ENABLED"      envDefault:"false"`
+    CacheMaxEntries     int             `env:"CACHE_MAX_ENTRIES"  envDefault:"1024"      validate:"gt=0"`
+    CacheMaxBytes       int64           `env:"CACHE_MAX_BYTES"    envDefault:"67108864"  validate:"gt=0"`
+    CacheTTL            time.Duration   `env:"CACHE_TTL"          envDefault:"30s"       validate:"gt=0"`
+    CacheServeStale     bool            `env:"CACHE_SERVE_STALE"  envDefault:"false"`
+
+    WriteBehindEnabled      bool            `env:"WRITE_BEHIND_ENABLED"     envDefault:"false"`
+    WriteBehindBatchSize    int             `env:"WRITE_BEHIND_BATCH_SIZE"  envDefault:"512"       validate:"gt=0"`
+    WriteBehindInterval     time.Duration   `env:"WRITE_BEHIND_INTERVAL"    envDefault:"100ms"     validate:"gt=0"`
+    WriteBehindMaxBytes     int64           `env:"WRITE_BEHIND_MAX_BYTES"   envDefault:"16777216"  validate:"gt=0"`
+
+    BackupDir           string          `env:"BACKUP_DIR"       envDefault:""`
+    BackupInterval      time.Duration   `env:"BACKUP_INTERVAL"  envDefault:"1h"    validate:"gt=0"`
+    BackupKeep          int             `env:"BACKUP_KEEP"      envDefault:"7"     validate:"gte=1"`
+    BackupMaxAge        time.Duration   `env:"BACKUP_MAX_AGE"   envDefault:"168h"  validate:"gte=0"`
+
+    AdminToken          string          `env:"ADMIN_TOKEN"      envDefault:""  redact:"true"`
+    AdminHost           string          `env:"ADMIN_HOST"       envDefault:"127.0.0.1"`
+    AdminPort           int16           `env:"ADMIN_PORT"       envDefault:"0"      validate:"gte=0"`
+
+    DebugEnabled        bool            `env:"DEBUG_ENABLED"    envDefault:"false"`
+
+    EnvRedactPatterns   []string        `env:"ENV_REDACT_PATTERNS"  envSeparator:","  envDefault:"*PASSWORD*,*PASSWD*,*SECRET*,*TOKEN*,*KEY*,*CREDENTIAL*,*AUTH*,*PRIVATE*"`
+
+    OTLPEndpoint        string          `env:"OTLP_ENDPOINT"    envDefault:""     redact:"url"`
+    OTLPHeaders         []string        `env:"OTLP_HEADERS"     envSeparator:","  redact:"true"`
+    OTLPInterval        time.Duration   `env:"OTLP_INTERVAL"    envDefault:"60s"  validate:"gt=0"`
+    OTLPTimeout         time.Duration   `env:"OTLP_TIMEOUT"     envDefault:"10s"  validate:"gt=0"`
+
+    TracingSampleRatio  float64         `env:"TRACING_SAMPLE_RATIO"  envDefault:"1"  validate:"gte=0,lte=1"`
+
+    origins     map[ string ] string
+    live        *liveSettings
 }
 
 
-func New() ( *Config, error ){
+func ( s *Sources ) Load() ( *Config, error ){
+    environment, origins, err := s.environment()
+    if err != nil {
+        return nil, err
+    }
+
     cfg := Config{
         Version: version,
+        origins: origins,
     }
 
-    if err := configParser.Parse( &cfg ); err != nil {
-        return nil, err
+    err = configParser.ParseWithOptions( &cfg, configParser.Options{
+        Environment: environment,
+    })
+    if err != nil {
+        return nil, describeParseError( err, origins )
+    }
+
+    if err := validator.New().Struct( &cfg ); err != nil {
+        return nil, describeValidationError( err, origins )
     }
 
     possibleEnvValues := map[ string ] bool {
@@ -51,54 +164,183 @@ func New() ( *Config, error ){
         "production":   true,
     }
     if _, ok := possibleEnvValues[ cfg.Environment ]; !ok {
-        return nil, errors.New(
-            fmt.Sprintf( "Invalid environment value: %s", cfg.Environment ),
+        return nil, describeSettingError(
+            origins,
+            fmt.Sprintf( "%s is not one of development, testing or production", cfg.Environment ),
+            "ENV_NAME",
         )
     }
 
 
-    if cfg.Environment == "development" { cfg.LogLevel = "debug" }
+    if len( cfg.ReleaseId ) <= 0 { cfg.ReleaseId = cfg.Version }
+
+    logLevelKey := "LOG_LEVEL"
+    if len( cfg.LogLevel ) <= 0 && len( cfg.LogLevelLegacy ) >= 1 {
+        cfg.LogLevel = cfg.LogLevelLegacy
+        logLevelKey = "LOG_LEVE"
+    }
+    if len( cfg.LogLevel ) <= 0 && cfg.Environment == "development" { cfg.LogLevel = "debug" }
+    if len( cfg.LogLevel ) <= 0 { cfg.LogLevel = "error" }
 
     if _, err := cfg.GetLogLevel(); err != nil {
+        return nil, describeSettingError(
+            origins,
+            fmt.Sprintf( "%s is not one of error, warn, info or debug", cfg.LogLevel ),
+            logLevelKey,
+        )
+    }
+
+    databaseModes := []string{}
+    if len( cfg.DatabaseURL ) >= 1 { databaseModes = append( databaseModes, "DB_URL" ) }
+    if len( cfg.DatabaseHost ) >= 1 { databaseModes = append( databaseModes, "DB_HOST" ) }
+    if len( cfg.DatabaseSentinelMaster ) >= 1 {
+        databaseModes = append( databaseModes, "DB_SENTINEL_MASTER" )
+    } else if len( cfg.DatabaseSentinelAddresses ) >= 1 {
+        databaseModes = append( databaseModes, "DB_SENTINEL_ADDRS" )
+    }
+    if len( cfg.DatabaseClusterAddresses ) >= 1 {
+        databaseModes = append( databaseModes, "DB_CLUSTER_ADDRS" )
+    }
+    if len( databaseModes ) >= 2 {
+        return nil, describeSettingError( origins, "mutually exclusive", databaseModes... )
+    }
+
+    if len( cfg.DatabaseURL ) >= 1 {
+        // parsed the way the client is set up, so that unknown or malformed
+        // query parameters are rejected rather than dropped
+        if _, err := db.ParseURL( cfg.DatabaseURL ); err != nil {
+            return nil, describeSettingError( origins, urlProblem( err ), "DB_URL" )
+        }
+    }
+
+    if len( cfg.DatabaseSentinelMaster ) <= 0 && len( cfg.DatabaseSentinelAddresses ) >= 1 {
+        return nil, describeSettingError(
+            origins, "required along with DB_SENTINEL_ADDRS", "DB_SENTINEL_MASTER",
+        )
+    }
+    if len( cfg.DatabaseSentinelMaster ) >= 1 && len( cfg.DatabaseSentinelAddresses ) <= 0 {
+        return nil, describeSettingError(
+            origins, "required along with DB_SENTINEL_MASTER", "DB_SENTINEL_ADDRS",
+        )
+    }
+
+    if len( cfg.DatabaseClusterAddresses ) >= 1 && cfg.DatabaseName != 0 {
+        return nil, describeSettingError(
+            origins, "a cluster only supports database 0", "DB_CLUSTER_ADDRS", "DB_NAME",
+        )
+    }
+
+    if cfg.HasDatabase() {
+        if err := checkSecretFile( origins, "DB_PASSWORD", cfg.DatabasePassword ); err != nil {
+            return nil, err
+        }
+        if err := checkSecretFile( origins, "DB_SENTINEL_PASSWORD", cfg.DatabaseSentinelPassword ); err != nil {
+            return nil, err
+        }
+    }
+
+    if err := checkSecretFile( origins, "ADMIN_TOKEN", cfg.AdminToken ); err != nil {
         return nil, err
     }
+    for _, pattern := range cfg.EnvRedactPatterns {
+        if _, err := path.Match( pattern, "" ); err != nil {
+            return nil, describeSettingError(
+                origins, fmt.Sprintf( "%s is not a valid pattern", pattern ), "ENV_REDACT_PATTERNS",
+            )
+        }
+    }
 
-    if len( cfg.DatabaseHost ) >= 1 && len( cfg.DatabasePassword ) >= 2 {
-        if ! fp.IsLocal( cfg.DatabasePassword ) && ! fp.IsAbs( cfg.DatabasePassword ) {
-            return nil, errors.New(
-                fmt.Sprintln( "Database password must be a file path" ),
+    if cfg.AdminPort >= 1 && cfg.AdminPort == cfg.Port && cfg.AdminHost == cfg.Host {
+        return nil, describeSettingError(
+            origins, "the admin listener has to differ from the HTTP listener", "ADMIN_PORT", "PORT",
+        )
+    }
+
+    if cfg.AdminPort >= 1 && len( cfg.AdminToken ) <= 0 && !isLoopback( cfg.AdminHost ) {
+        return nil, describeSettingError(
+            origins,
+            fmt.Sprintf( "the admin listener on %s is not loopback and requires a token", cfg.AdminHost ),
+            "ADMIN_HOST", "ADMIN_TOKEN",
+        )
+    }
+
+    if _, err := cfg.GetDatabaseTLSMinVersion(); err != nil {
+        return nil, describeSettingError(
+            origins,
+            fmt.Sprintf( "%s is not one of 1.0, 1.1, 1.2 or 1.3", cfg.DatabaseTLSMinVersion ),
+            "DB_TLS_MIN_VERSION",
+        )
+    }
+    if ( len( cfg.DatabaseTLSCertFile ) >= 1 ) != ( len( cfg.DatabaseTLSKeyFile ) >= 1 ) {
+        return nil, describeSettingError(
+            origins, "have to be set together", "DB_TLS_CERT_FILE", "DB_TLS_KEY_FILE",
+        )
+    }
+    tlsFiles := []struct{ key, path string }{
+        { "DB_TLS_CA_FILE", cfg.DatabaseTLSCAFile },
+        { "DB_TLS_CERT_FILE", cfg.DatabaseTLSCertFile },
+        { "DB_TLS_KEY_FILE", cfg.DatabaseTLSKeyFile },
+    }
+    if ! cfg.UsesDatabaseTLS() {
+        tlsOptions := []string{}
+        for _, file := range tlsFiles {
+            if len( file.path ) >= 1 {
+                tlsOptions = append( tlsOptions, file.key )
+            }
+        }
+        if len( cfg.DatabaseTLSServerName ) >= 1 {
+            tlsOptions = append( tlsOptions, "DB_TLS_SERVER_NAME" )
+        }
+        if len( tlsOptions ) >= 1 {
+            return nil, describeSettingError(
+                origins, "requires DB_TLS or a rediss:// URL", append( tlsOptions, "DB_TLS" )...,
             )
         }
-        _, err := os.Stat( cfg.DatabasePassword )
-        if err != nil {
-            if errors.Is( err, os.ErrNotExist ){
-                return nil, errors.New(
-                    fmt.Sprintln( "Database password file does not exist" ),
-                )
+    } else if cfg.HasDatabase() {
+        for _, file := range tlsFiles {
+            if err := checkSecretFile( origins, file.key, file.path ); err != nil {
+                return nil, err
             }
-            return nil, errors.New(
-                fmt.Sprintln( "Database password file not accessible" ),
+        }
+    }
+
+    if len( cfg.OTLPEndpoint ) >= 1 {
+        endpoint, err := url.Parse( cfg.OTLPEndpoint )
+        if err != nil {
+            return nil, describeSettingError( origins, urlProblem( err ), "OTLP_ENDPOINT" )
+        }
+        if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+            return nil, describeSettingError(
+                origins,
+                fmt.Sprintf( "scheme %s is neither http nor https", endpoint.Scheme ),
+                "OTLP_ENDPOINT",
             )
         }
     }
+    if _, err := cfg.GetOTLPHeaders(); err != nil {
+        // the value is not included, as headers usually carry credentials
+        return nil, describeSettingError( origins, "expected key=value pairs", "OTLP_HEADERS" )
+    }
+
+    if _, err := cfg.GetAccessLogTemplate(); err != nil {
+        return nil, describeSettingError( origins, err.Error(), "ACCESS_LOG_FORMAT" )
+    }
 
     if len( cfg.FontColor ) >= 1 {
         if len( cfg.FontColor ) >= 21 {
-            return nil, errors.New(
-                fmt.Sprintln( "Font color too long" ),
-            )
+            return nil, describeSettingError( origins, "longer than 20 characters", "FONT_COLOR" )
         }
 
         for _, r := range cfg.FontColor {
             if ! unicode.IsLetter( r ) {
-                return nil, errors.New(
-                    fmt.Sprintln( "Invalid character in font color" ),
-                )
+                return nil, describeSettingError( origins, "letters only are allowed", "FONT_COLOR" )
             }
         }
     }
 
 
+    cfg.live = &liveSettings{ applied: &cfg }
+
     return &cfg, nil
 }
 
@@ -106,6 +348,8 @@ func New() ( *Config, error ){
 func ( cfg *Config ) GetLogLevel() ( slog.Level, error ){
     possibleLogLevels := map[ string ] slog.Level {
         "error":    slog.LevelError,
+        "warn":     slog.LevelWarn,
+        "info":     slog.LevelInfo,
         "debug":    slog.LevelDebug,
     }
     level, ok := possibleLogLevels[ cfg.LogLevel ]
@@ -116,4 +360,117 @@ func ( cfg *Config ) GetLogLevel() ( slog.Level, error ){
     }else{
         return level, nil
     }
-}
\ No newline at end of file
+}
+
+
+func ( cfg *Config ) GetDatabaseTLSMinVersion() ( uint16, error ){
+    possibleVersions := map[ string ] uint16 {
+        "1.0":  tls.VersionTLS10,
+        "1.1":  tls.VersionTLS11,
+        "1.2":  tls.VersionTLS12,
+        "1.3":  tls.VersionTLS13,
+    }
+    version, ok := possibleVersions[ cfg.DatabaseTLSMinVersion ]
+    if !ok {
+        return tls.VersionTLS12, errors.New(
+            fmt.Sprintf( "Invalid database TLS version: %s", cfg.DatabaseTLSMinVersion ),
+        )
+    }
+    return version, nil
+}
+
+
+// GetOTLPHeaders parses the key=value pairs sent along with every export
+func ( cfg *Config ) GetOTLPHeaders() ( map[ string ] string, error ){
+    headers := map[ string ] string {}
+    for _, header := range cfg.OTLPHeaders {
+        key, value, found := strings.Cut( header, "=" )
+        key = strings.TrimSpace( key )
+        if !found || len( key ) <= 0 {
+            // the value is not included, as headers usually carry credentials
+            return nil, errors.New(
+                fmt.Sprintln( "Invalid OTLP header, expected key=value" ),
+            )
+        }
+        headers[ key ] = strings.TrimSpace( value )
+    }
+    return headers, nil
+}
+
+
+// combinedLogFormat is the Apache combined log format followed by the
+// duration in seconds and the request id
+const combinedLogFormat = `{{ .ClientIp }} - - [{{ .Time.Format "02/Jan/2006:15:04:05 -0700" }}] ` +
+    `"{{ .Method }} {{ escape .Path }} {{ .Protocol }}" {{ .Status }} {{ .Bytes }} ` +
+    `"{{ or .Referer "-" | escape }}" "{{ or .Agent "-" | escape }}" {{ printf "%.6f" .Duration.Seconds }} "{{ .RequestId }}"`
+
+
+// escape keeps quotes and control characters from breaking up a line
+func escape( value string ) string {
+    quoted := strconv.Quote( value )
+    return quoted[ 1 : len( quoted ) - 1 ]
+}
+
+
+// GetAccessLogTemplate parses the access log format, it is nil for json
+func ( cfg *Config ) GetAccessLogTemplate() ( *template.Template, error ){
+    format := cfg.AccessLogFormat
+    switch format {
+    case "json":
+        return nil, nil
+    case "combined":
+        format = combinedLogFormat
+    }
+    return template.New( "access" ).Funcs( template.FuncMap{ "escape": escape } ).Parse( format )
+}
+
+
+func ( cfg *Config ) UsesDatabaseTLS() bool {
+    return cfg.DatabaseTLS || strings.HasPrefix( cfg.DatabaseURL, "rediss://" )
+}
+
+
+func ( cfg *Config ) HasDatabase() bool {
+    return len( cfg.DatabaseURL ) >= 1 ||
+        len( cfg.DatabaseHost ) >= 1 ||
+        len( cfg.DatabaseSentinelMaster ) >= 1 ||
+        len( cfg.DatabaseClusterAddresses ) >= 1
+}
+
+
+func isLoopback( host string ) bool {
+    if host == "localhost" {
+        return true
+    }
+    ip := net.ParseIP( host )
+    return ip != nil && ip.IsLoopback()
+}
+
+
+func checkSecretFile( origins map[ string ] string, key string, path string ) error {
+    if len( path ) < 2 {
+        return nil
+    }
+
+    if ! fp.IsLocal( path ) && ! fp.IsAbs( path ) {
+        return describeSettingError( origins, "must be a file path", key )
+    }
+    _, err := os.Stat( path )
+    if err != nil {
+        if errors.Is( err, os.ErrNotExist ){
+            return describeSettingError( origins, "file does not exist", key )
+        }
+        return describeSettingError( origins, "file not accessible", key )
+    }
+    return nil
+}
+
+
+// urlProblem leaves the URL out of a parse error, as it may carry credentials
+func urlProblem( err error ) string {
+    urlErr := &url.Error{}
+    if errors.As( err, &urlErr ) {
+        return urlErr.Err.Error()
+    }
+    return err.Error()
+}
@@ -0,0 +1,91 @@
+package configuration
+
+import (
+    "maps"
+    "reflect"
+    "sync"
+)
+
+
+// reloadableSettings are applied to a running service, all others only
+// take effect after a restart
+var reloadableSettings = map[ string ] bool {
+    "LOG_LEVEL":    true,
+    "LOG_LEVE":     true,
+    "FONT_COLOR":   true,
+}
+
+
+type liveSettings struct {
+    mux     sync.RWMutex
+    applied *Config
+}
+
+
+func ( cfg *Config ) current() *Config {
+    if cfg.live == nil {
+        return cfg
+    }
+    cfg.live.mux.RLock()
+    defer cfg.live.mux.RUnlock()
+
+    return cfg.live.applied
+}
+
+
+func ( cfg *Config ) GetFontColor() string {
+    return cfg.current().FontColor
+}
+
+
+func rawSettings( cfg *Config ) map[ string ] string {
+    value := reflect.ValueOf( cfg ).Elem()
+    values := map[ string ] string {}
+    for _, field := range settingFields() {
+        values[ settingKey( field ) ] = formatSetting( value.FieldByIndex( field.Index ).Interface() )
+    }
+    return values
+}
+
+
+// Reload applies the reloadable settings of next, which has to be valid,
+// and returns the keys of the settings applied as well as of the settings
+// which differ but only apply after a restart
+func ( cfg *Config ) Reload( next *Config ) ( applied []string, pending []string ) {
+    if cfg.live == nil {
+        return nil, nil
+    }
+    cfg.live.mux.Lock()
+    defer cfg.live.mux.Unlock()
+
+    running := rawSettings( cfg )
+    previous := rawSettings( cfg.live.applied )
+    upcoming := rawSettings( next )
+
+    // the settings which only apply after a restart keep their running value
+    updated := *cfg.live.applied
+    updated.origins = maps.Clone( cfg.live.applied.origins )
+    source := reflect.ValueOf( next ).Elem()
+    target := reflect.ValueOf( &updated ).Elem()
+
+    for _, field := range settingFields() {
+        key := settingKey( field )
+        if reloadableSettings[ key ] {
+            target.FieldByIndex( field.Index ).Set( source.FieldByIndex( field.Index ) )
+            if origin, found := next.origins[ key ]; found {
+                updated.origins[ key ] = origin
+            } else {
+                delete( updated.origins, key )
+            }
+        }
+        switch {
+        case reloadableSettings[ key ] && previous[ key ] != upcoming[ key ]:
+            applied = append( applied, key )
+        case !reloadableSettings[ key ] && running[ key ] != upcoming[ key ]:
+            pending = append( pending, key )
+        }
+    }
+
+    cfg.live.applied = &updated
+    return applied, pending
+}
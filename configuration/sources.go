@@ -0,0 +1,403 @@
+package configuration
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "net/url"
+    "os"
+    "path"
+    "reflect"
+    "strings"
+    "time"
+    fp "path/filepath"
+
+    configParser "github.com/caarlos0/env/v9"
+    "github.com/go-playground/validator/v10"
+    "github.com/BurntSushi/toml"
+    "gopkg.in/yaml.v3"
+)
+
+
+const CONFIG_FILE_VARIABLE = "CONFIG_FILE"
+
+const (
+    originDefault       = "default"
+    originEnvironment   = "environment"
+)
+
+
+// Sources collects configuration values from a file, the environment and
+// flags, in increasing precedence over the defaults
+type Sources struct {
+    file    string
+    flags   map[ string ] string
+}
+
+
+type Setting struct {
+    Key     string  `json:"key"     yaml:"key"`
+    Value   string  `json:"value"   yaml:"value"`
+    Origin  string  `json:"origin"  yaml:"origin"`
+}
+
+
+type settingFlag struct {
+    sources *Sources
+    key     string
+    isBool  bool
+}
+
+func ( f *settingFlag ) String() string {
+    if f.sources == nil {
+        return ""
+    }
+    return f.sources.flags[ f.key ]
+}
+
+func ( f *settingFlag ) Set( value string ) error {
+    f.sources.flags[ f.key ] = value
+    return nil
+}
+
+func ( f *settingFlag ) IsBoolFlag() bool {
+    return f.isBool
+}
+
+
+func settingFields() []reflect.StructField {
+    fields := []reflect.StructField{}
+    configType := reflect.TypeOf( Config{} )
+    for i := 0; i < configType.NumField(); i++ {
+        if _, found := configType.Field( i ).Tag.Lookup( "env" ); found {
+            fields = append( fields, configType.Field( i ) )
+        }
+    }
+    return fields
+}
+
+
+func settingKey( field reflect.StructField ) string {
+    key, _, _ := strings.Cut( field.Tag.Get( "env" ), "," )
+    return key
+}
+
+
+func FlagName( key string ) string {
+    return strings.ReplaceAll( strings.ToLower( key ), "_", "-" )
+}
+
+
+func BindFlags( flags *flag.FlagSet ) *Sources {
+    s := &Sources{
+        flags: map[ string ] string {},
+    }
+    flags.StringVar(
+        &s.file, "config", "",
+        fmt.Sprintf( "YAML, TOML or JSON configuration file, defaults to %s", CONFIG_FILE_VARIABLE ),
+    )
+    for _, field := range settingFields() {
+        key := settingKey( field )
+        flags.Var(
+            &settingFlag{ sources: s, key: key, isBool: field.Type.Kind() == reflect.Bool },
+            FlagName( key ),
+            fmt.Sprintf( "overrides %s", key ),
+        )
+    }
+    return s
+}
+
+
+func New() ( *Config, error ){
+    return ( &Sources{} ).Load()
+}
+
+
+func ( s *Sources ) environment() ( map[ string ] string, map[ string ] string, error ) {
+    known := map[ string ] bool {}
+    for _, field := range settingFields() {
+        known[ settingKey( field ) ] = true
+    }
+
+    environment := map[ string ] string {}
+    origins := map[ string ] string {}
+
+    file := s.file
+    if len( file ) <= 0 {
+        file = os.Getenv( CONFIG_FILE_VARIABLE )
+    }
+    if len( file ) >= 1 {
+        values, err := readConfigFile( file, known )
+        if err != nil {
+            return nil, nil, err
+        }
+        for key, value := range values {
+            environment[ key ] = value
+            origins[ key ] = fmt.Sprintf( "file %s", file )
+        }
+    }
+
+    for _, variable := range os.Environ() {
+        key, value, _ := strings.Cut( variable, "=" )
+        environment[ key ] = value
+        if known[ key ] {
+            origins[ key ] = originEnvironment
+        }
+    }
+
+    for key, value := range s.flags {
+        environment[ key ] = value
+        origins[ key ] = fmt.Sprintf( "flag --%s", FlagName( key ) )
+    }
+
+    return environment, origins, nil
+}
+
+
+func readConfigFile( path string, known map[ string ] bool ) ( map[ string ] string, error ) {
+    content, err := os.ReadFile( path )
+    if err != nil {
+        return nil, errors.New(
+            fmt.Sprintf( "Configuration file not readable: %v", err ),
+        )
+    }
+
+    document := map[ string ] any {}
+    switch strings.ToLower( fp.Ext( path ) ) {
+    case ".yaml", ".yml":
+        err = yaml.Unmarshal( content, &document )
+    case ".toml":
+        err = toml.Unmarshal( content, &document )
+    case ".json":
+        decoder := json.NewDecoder( bytes.NewReader( content ) )
+        decoder.UseNumber()
+        err = decoder.Decode( &document )
+    default:
+        return nil, errors.New(
+            fmt.Sprintf( "Unsupported configuration file format: %s", path ),
+        )
+    }
+    if err != nil {
+        return nil, errors.New(
+            fmt.Sprintf( "Invalid configuration file %s: %v", path, err ),
+        )
+    }
+
+    values := map[ string ] string {}
+    if err := flatten( "", document, values ); err != nil {
+        return nil, errors.New(
+            fmt.Sprintf( "Invalid configuration file %s: %v", path, err ),
+        )
+    }
+    for key := range values {
+        if !known[ normalizeKey( key ) ] {
+            return nil, errors.New(
+                fmt.Sprintf( "Unknown configuration key %s in %s", key, path ),
+            )
+        }
+    }
+
+    normalized := map[ string ] string {}
+    for key, value := range values {
+        normalized[ normalizeKey( key ) ] = value
+    }
+    return normalized, nil
+}
+
+
+func normalizeKey( key string ) string {
+    return strings.ToUpper( strings.NewReplacer( ".", "_", "-", "_" ).Replace( key ) )
+}
+
+
+// flatten turns nested tables into keys joined by dots and lists into
+// comma separated values, the way they are given as environment variables
+func flatten( prefix string, value any, values map[ string ] string ) error {
+    switch typed := value.( type ) {
+    case map[ string ] any:
+        for key, nested := range typed {
+            if len( prefix ) >= 1 {
+                key = prefix + "." + key
+            }
+            if err := flatten( key, nested, values ); err != nil {
+                return err
+            }
+        }
+    case []any:
+        elements := make( []string, len( typed ) )
+        for i, element := range typed {
+            switch element.( type ) {
+            case map[ string ] any, []any:
+                return errors.New(
+                    fmt.Sprintf( "Key %s only supports a list of values", prefix ),
+                )
+            }
+            elements[ i ] = fmt.Sprint( element )
+        }
+        values[ prefix ] = strings.Join( elements, "," )
+    case nil:
+        values[ prefix ] = ""
+    default:
+        values[ prefix ] = fmt.Sprint( typed )
+    }
+    return nil
+}
+
+
+func describeParseError( err error, origins map[ string ] string ) error {
+    aggregate := configParser.AggregateError{}
+    if !errors.As( err, &aggregate ) {
+        return err
+    }
+
+    keys := map[ string ] string {}
+    for _, field := range settingFields() {
+        keys[ field.Name ] = settingKey( field )
+    }
+
+    described := make( []error, 0, len( aggregate.Errors ) )
+    for _, nested := range aggregate.Errors {
+        parseErr := configParser.ParseError{}
+        if !errors.As( nested, &parseErr ) {
+            described = append( described, nested )
+            continue
+        }
+        key := keys[ parseErr.Name ]
+        described = append( described, errors.New(
+            fmt.Sprintf(
+                "Invalid value for %s from %s: %v",
+                key, originOf( origins, key ), parseErr.Err,
+            ),
+        ))
+    }
+    return errors.Join( described... )
+}
+
+
+func describeValidationError( err error, origins map[ string ] string ) error {
+    validationErrs := validator.ValidationErrors{}
+    if !errors.As( err, &validationErrs ) {
+        return err
+    }
+
+    keys := map[ string ] string {}
+    for _, field := range settingFields() {
+        keys[ field.Name ] = settingKey( field )
+    }
+
+    described := make( []error, 0, len( validationErrs ) )
+    for _, fieldErr := range validationErrs {
+        key := keys[ fieldErr.StructField() ]
+        rule := fieldErr.Tag()
+        if len( fieldErr.Param() ) >= 1 {
+            rule = fmt.Sprintf( "%s %s", rule, fieldErr.Param() )
+            if paramKey, found := keys[ fieldErr.Param() ]; found {
+                rule = fmt.Sprintf( "%s %s", fieldErr.Tag(), paramKey )
+            }
+        }
+        described = append( described, errors.New(
+            fmt.Sprintf(
+                "Invalid value %v for %s from %s: must be %s",
+                fieldErr.Value(), key, originOf( origins, key ), rule,
+            ),
+        ))
+    }
+    return errors.Join( described... )
+}
+
+
+// describeSettingError ties the error of a custom validation to the settings
+// it concerns and where their values originate from
+func describeSettingError( origins map[ string ] string, problem string, keys ...string ) error {
+    described := make( []string, len( keys ) )
+    for i, key := range keys {
+        described[ i ] = fmt.Sprintf( "%s from %s", key, originOf( origins, key ) )
+    }
+    if len( described ) == 1 {
+        return errors.New(
+            fmt.Sprintf( "Invalid value for %s: %s", described[ 0 ], problem ),
+        )
+    }
+    return errors.New(
+        fmt.Sprintf( "Invalid combination of %s: %s", strings.Join( described, ", " ), problem ),
+    )
+}
+
+
+func originOf( origins map[ string ] string, key string ) string {
+    if origin, found := origins[ key ]; found {
+        return origin
+    }
+    return originDefault
+}
+
+
+// Settings lists the effective value of every setting along with where it
+// originates from, values of fields tagged redact are hidden and passwords
+// of fields tagged redact:"url" are masked
+func ( cfg *Config ) Settings() []Setting {
+    cfg = cfg.current()
+    value := reflect.ValueOf( cfg ).Elem()
+    settings := []Setting{}
+    for _, field := range settingFields() {
+        key := settingKey( field )
+        formatted := formatSetting( value.FieldByIndex( field.Index ).Interface() )
+        switch field.Tag.Get( "redact" ) {
+        case "true":
+            if len( formatted ) >= 1 {
+                formatted = "[redacted]"
+            }
+        case "url":
+            if parsed, err := url.Parse( formatted ); err == nil {
+                formatted = parsed.Redacted()
+            }
+        }
+        settings = append( settings, Setting{
+            Key: key,
+            Value: formatted,
+            Origin: originOf( cfg.origins, key ),
+        })
+    }
+    return settings
+}
+
+
+// RedactVariable masks the value of an environment variable if its name
+// matches a redaction pattern or it holds a secret setting, and the password
+// of a value which is a URL
+func ( cfg *Config ) RedactVariable( name string, value string ) string {
+    if len( value ) <= 0 {
+        return value
+    }
+    for _, field := range settingFields() {
+        if settingKey( field ) == name && field.Tag.Get( "redact" ) == "true" {
+            return "[redacted]"
+        }
+    }
+    for _, pattern := range cfg.EnvRedactPatterns {
+        if matched, _ := path.Match( strings.ToUpper( pattern ), strings.ToUpper( name ) ); matched {
+            return "[redacted]"
+        }
+    }
+    if parsed, err := url.Parse( value ); err == nil && parsed.User != nil {
+        if _, found := parsed.User.Password(); found {
+            return parsed.Redacted()
+        }
+    }
+    return value
+}
+
+
+func formatSetting( value any ) string {
+    switch typed := value.( type ) {
+    case time.Duration:
+        return typed.String()
+    case []string:
+        return strings.Join( typed, "," )
+    default:
+        return fmt.Sprint( typed )
+    }
+}
+
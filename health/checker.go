@@ -0,0 +1,149 @@
+package health
+
+import (
+    "sync"
+    "time"
+)
+
+
+const (
+    StatusPass = "pass"
+    StatusWarn = "warn"
+    StatusFail = "fail"
+)
+
+
+type Check struct {
+    ComponentId     string  `json:"componentId,omitempty"`
+    ComponentType   string  `json:"componentType,omitempty"`
+    ObservedValue   any     `json:"observedValue,omitempty"`
+    ObservedUnit    string  `json:"observedUnit,omitempty"`
+    Status          string  `json:"status"  validate:"oneof=pass warn fail"`
+    Time            string  `json:"time,omitempty"`
+    Output          string  `json:"output,omitempty"`
+}
+
+type Probe func() Check
+
+
+type Checker struct {
+    interval    time.Duration
+    names       []string
+    probes      map[ string ] Probe
+    results     map[ string ] []Check
+    mux         sync.RWMutex
+
+    stop        chan struct{}
+    stopping    sync.Once
+}
+
+
+func NewChecker( interval time.Duration ) *Checker {
+    return &Checker{
+        interval: interval,
+        probes: map[ string ] Probe {},
+        results: map[ string ] []Check {},
+        stop: make( chan struct{} ),
+    }
+}
+
+
+func ( c *Checker ) Register( name string, probe Probe ) {
+    c.mux.Lock()
+    defer c.mux.Unlock()
+
+    if _, found := c.probes[ name ]; !found {
+        c.names = append( c.names, name )
+    }
+    c.probes[ name ] = probe
+}
+
+
+func ( c *Checker ) Run() {
+    c.mux.RLock()
+    names := append( []string{}, c.names... )
+    probes := make( []Probe, len( names ) )
+    for i, name := range names {
+        probes[ i ] = c.probes[ name ]
+    }
+    c.mux.RUnlock()
+
+    results := make( []Check, len( probes ) )
+    wg := sync.WaitGroup{}
+    for i, probe := range probes {
+        wg.Add( 1 )
+        go func( i int, probe Probe ){
+            defer wg.Done()
+            results[ i ] = probe()
+            if len( results[ i ].Time ) <= 0 {
+                results[ i ].Time = time.Now().UTC().Format( time.RFC3339 )
+            }
+        }( i, probe )
+    }
+    wg.Wait()
+
+    c.mux.Lock()
+    for i, name := range names {
+        c.results[ name ] = []Check{ results[ i ] }
+    }
+    c.mux.Unlock()
+}
+
+
+// Start runs the checks in the background, the first right away without
+// waiting for it, as probes may block until they time out
+func ( c *Checker ) Start() {
+    go func(){
+        c.Run()
+        ticker := time.NewTicker( c.interval )
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                c.Run()
+            case <-c.stop:
+                return
+            }
+        }
+    }()
+}
+
+
+func ( c *Checker ) Stop() {
+    c.stopping.Do( func(){
+        close( c.stop )
+    })
+}
+
+
+func ( c *Checker ) Results() ( string, map[ string ] []Check ) {
+    c.mux.RLock()
+    defer c.mux.RUnlock()
+
+    status := StatusPass
+    checks := make( map[ string ] []Check, len( c.names ) )
+    for _, name := range c.names {
+        results, found := c.results[ name ]
+        if !found {
+            results = []Check{ { Status: StatusWarn, Output: "Not checked yet" } }
+        }
+        checks[ name ] = append( []Check{}, results... )
+        for _, result := range results {
+            status = Worst( status, result.Status )
+        }
+    }
+    return status, checks
+}
+
+
+func Worst( a string, b string ) string {
+    severity := map[ string ] int {
+        StatusPass: 0,
+        StatusWarn: 1,
+        StatusFail: 2,
+    }
+    if severity[ b ] > severity[ a ] {
+        return b
+    }
+    return a
+}
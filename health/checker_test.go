@@ -0,0 +1,41 @@
+package health
+
+import (
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func TestCheckerStart( t *testing.T ){
+    release := make( chan struct{} )
+    checker := NewChecker( time.Hour )
+    checker.Register( "store", func() Check {
+        <-release
+        return Check{ Status: StatusPass }
+    })
+    defer checker.Stop()
+
+    // the first check does not hold up starting
+    started := make( chan struct{} )
+    go func(){
+        checker.Start()
+        close( started )
+    }()
+    select {
+    case <-started:
+    case <-time.After( time.Second ):
+        t.Fatal( "Start waited for the first check" )
+    }
+
+    status, checks := checker.Results()
+    assert.Equal( t, StatusWarn, status )
+    assert.Equal( t, "Not checked yet", checks[ "store" ][ 0 ].Output )
+
+    close( release )
+    assert.Eventually( t, func() bool {
+        status, _ := checker.Results()
+        return status == StatusPass
+    }, time.Second, time.Millisecond * 10 )
+}
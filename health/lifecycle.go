@@ -0,0 +1,92 @@
+package health
+
+import (
+    "errors"
+    "fmt"
+    "sync/atomic"
+)
+
+
+type Phase int32
+
+const (
+    PhaseStarting Phase = iota
+    PhaseReady
+    PhaseDraining
+    PhaseStopping
+)
+
+
+func ( p Phase ) String() string {
+    switch p {
+    case PhaseStarting:
+        return "starting"
+    case PhaseReady:
+        return "ready"
+    case PhaseDraining:
+        return "draining"
+    case PhaseStopping:
+        return "stopping"
+    default:
+        return fmt.Sprintf( "unknown(%d)", int32( p ) )
+    }
+}
+
+
+type Lifecycle struct {
+    phase atomic.Int32
+}
+
+
+func NewLifecycle() *Lifecycle {
+    return &Lifecycle{}
+}
+
+
+func ( l *Lifecycle ) Phase() Phase {
+    return Phase( l.phase.Load() )
+}
+
+
+func ( l *Lifecycle ) advance( to Phase ) error {
+    for {
+        from := l.phase.Load()
+        if Phase( from ) == to {
+            return nil
+        }
+        if Phase( from ) > to {
+            return errors.New(
+                fmt.Sprintf( "Lifecycle cannot change from %s to %s", Phase( from ), to ),
+            )
+        }
+        if l.phase.CompareAndSwap( from, int32( to ) ) {
+            return nil
+        }
+    }
+}
+
+
+func ( l *Lifecycle ) Ready() error {
+    return l.advance( PhaseReady )
+}
+
+func ( l *Lifecycle ) Drain() error {
+    return l.advance( PhaseDraining )
+}
+
+func ( l *Lifecycle ) Stop() error {
+    return l.advance( PhaseStopping )
+}
+
+
+func ( l *Lifecycle ) IsAlive() bool {
+    return true
+}
+
+func ( l *Lifecycle ) IsStarted() bool {
+    return l.Phase() != PhaseStarting
+}
+
+func ( l *Lifecycle ) IsReady() bool {
+    return l.Phase() == PhaseReady
+}
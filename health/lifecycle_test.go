@@ -0,0 +1,41 @@
+package health
+
+import (
+    "sync"
+    "testing"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func TestLifecycle( t *testing.T ){
+    l := NewLifecycle()
+    assert.Equal( t, PhaseStarting, l.Phase() )
+    assert.True( t, l.IsAlive() )
+    assert.False( t, l.IsStarted() )
+    assert.False( t, l.IsReady() )
+
+    wg := &sync.WaitGroup{}
+    for i := 0; i < 8; i++ {
+        wg.Add( 1 )
+        go func(){
+            defer wg.Done()
+            assert.Nil( t, l.Ready() )
+        }()
+    }
+    wg.Wait()
+    assert.True( t, l.IsStarted() )
+    assert.True( t, l.IsReady() )
+
+    assert.Nil( t, l.Drain() )
+    assert.Equal( t, PhaseDraining, l.Phase() )
+    assert.True( t, l.IsStarted() )
+    assert.False( t, l.IsReady() )
+
+    assert.NotNil( t, l.Ready() )
+    assert.Equal( t, PhaseDraining, l.Phase() )
+
+    assert.Nil( t, l.Stop() )
+    assert.Equal( t, "stopping", l.Phase().String() )
+    assert.True( t, l.IsAlive() )
+}
@@ -0,0 +1,45 @@
+package health
+
+import (
+    "context"
+    "time"
+
+    "webservice/state"
+)
+
+
+func StoreProbe( store state.Store, componentId string, warnLatency time.Duration ) Probe {
+    return func() Check {
+        started := time.Now()
+        err := store.Ping( context.Background() )
+        latency := time.Since( started )
+
+        check := Check{
+            ComponentId: componentId,
+            ComponentType: "datastore",
+            ObservedValue: float64( latency.Microseconds() ) / 1000,
+            ObservedUnit: "ms",
+            Status: StatusPass,
+        }
+        if err != nil {
+            check.Status = StatusFail
+            check.Output = err.Error()
+        } else if latency > warnLatency {
+            check.Status = StatusWarn
+            check.Output = "Store responds slowly"
+        }
+        return check
+    }
+}
+
+
+func UptimeProbe( started time.Time ) Probe {
+    return func() Check {
+        return Check{
+            ComponentType: "system",
+            ObservedValue: int64( time.Since( started ).Seconds() ),
+            ObservedUnit: "s",
+            Status: StatusPass,
+        }
+    }
+}
@@ -0,0 +1,289 @@
+package logging
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "slices"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    fp "path/filepath"
+
+    "webservice/configuration"
+)
+
+
+type contextKey struct{}
+
+
+// With returns a context whose attributes are added to every record
+// logged with it
+func With( ctx context.Context, attrs ...slog.Attr ) context.Context {
+    existing, _ := ctx.Value( contextKey{} ).( []slog.Attr )
+    return context.WithValue( ctx, contextKey{}, append( slices.Clip( existing ), attrs... ) )
+}
+
+
+func Attrs( ctx context.Context ) []slog.Attr {
+    attrs, _ := ctx.Value( contextKey{} ).( []slog.Attr )
+    return attrs
+}
+
+
+type requestIdKey struct{}
+
+
+// WithRequestId returns a context carrying the id of the request it
+// belongs to, which is added to every record logged with it
+func WithRequestId( ctx context.Context, id string ) context.Context {
+    ctx = context.WithValue( ctx, requestIdKey{}, id )
+    return With( ctx, slog.String( "requestId", id ) )
+}
+
+
+func RequestId( ctx context.Context ) string {
+    id, _ := ctx.Value( requestIdKey{} ).( string )
+    return id
+}
+
+
+type contextHandler struct {
+    slog.Handler
+}
+
+func ( h contextHandler ) Handle( ctx context.Context, record slog.Record ) error {
+    if ctx != nil {
+        record.AddAttrs( Attrs( ctx )... )
+    }
+    return h.Handler.Handle( ctx, record )
+}
+
+func ( h contextHandler ) WithAttrs( attrs []slog.Attr ) slog.Handler {
+    return contextHandler{ h.Handler.WithAttrs( attrs ) }
+}
+
+func ( h contextHandler ) WithGroup( name string ) slog.Handler {
+    return contextHandler{ h.Handler.WithGroup( name ) }
+}
+
+
+// reopeningFile appends to a file which is able to be reopened once it
+// got moved away by log rotation, or rotates it itself once it exceeds a
+// size or age limit
+type reopeningFile struct {
+    path        string
+    rotation    rotation
+    mux         sync.Mutex
+    file        *os.File
+    size        int64
+    opened      time.Time
+}
+
+type rotation struct {
+    maxSize     int64
+    maxAge      time.Duration
+    maxBackups  int
+}
+
+const rotationLayout = "20060102T150405.000000000"
+
+func openFile( path string, r rotation ) ( *reopeningFile, error ) {
+    f := &reopeningFile{ path: path, rotation: r }
+    return f, f.Reopen()
+}
+
+func ( f *reopeningFile ) Reopen() error {
+    f.mux.Lock()
+    defer f.mux.Unlock()
+    return f.reopen()
+}
+
+func ( f *reopeningFile ) reopen() error {
+    file, err := os.OpenFile( f.path, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0640 )
+    if err != nil {
+        return err
+    }
+    info, err := file.Stat()
+    if err != nil {
+        file.Close()
+        return err
+    }
+
+    previous := f.file
+    f.file = file
+    f.size = info.Size()
+    f.opened = time.Now()
+    if previous != nil {
+        return previous.Close()
+    }
+    return nil
+}
+
+func ( f *reopeningFile ) due( length int ) bool {
+    if f.size <= 0 {
+        return false
+    }
+    return ( f.rotation.maxSize > 0 && f.size + int64( length ) > f.rotation.maxSize ) ||
+           ( f.rotation.maxAge > 0 && time.Since( f.opened ) >= f.rotation.maxAge )
+}
+
+// rotate moves the file aside with a timestamp suffix, opens a new one and
+// removes the oldest moved files exceeding the number of backups to keep
+func ( f *reopeningFile ) rotate() error {
+    rotated := f.path + "." + time.Now().UTC().Format( rotationLayout )
+    if err := os.Rename( f.path, rotated ); err != nil {
+        return err
+    }
+    if err := f.reopen(); err != nil {
+        return err
+    }
+
+    candidates, err := fp.Glob( f.path + ".*" )
+    if err != nil {
+        return err
+    }
+    backups := []string{}
+    for _, candidate := range candidates {
+        suffix := strings.TrimPrefix( candidate, f.path + "." )
+        if _, err := time.Parse( rotationLayout, suffix ); err == nil {
+            backups = append( backups, candidate )
+        }
+    }
+    sort.Strings( backups )
+    for len( backups ) > f.rotation.maxBackups {
+        if err := os.Remove( backups[ 0 ] ); err != nil {
+            return err
+        }
+        backups = backups[ 1: ]
+    }
+    return nil
+}
+
+func ( f *reopeningFile ) Write( p []byte ) ( int, error ) {
+    f.mux.Lock()
+    defer f.mux.Unlock()
+
+    // the line is written regardless, a failed rotation is reported along
+    var rotationErr error
+    if f.due( len( p ) ) {
+        if err := f.rotate(); err != nil {
+            rotationErr = errors.New( fmt.Sprintf( "Log file %s not able to be rotated: %v", f.path, err ) )
+        }
+    }
+    n, err := f.file.Write( p )
+    f.size += int64( n )
+    if err != nil {
+        return n, err
+    }
+    return n, rotationErr
+}
+
+func ( f *reopeningFile ) Close() error {
+    f.mux.Lock()
+    defer f.mux.Unlock()
+    return f.file.Close()
+}
+
+
+type Logging struct {
+    level       *slog.LevelVar
+    file        *reopeningFile
+    access      io.Writer
+    accessFile  *reopeningFile
+}
+
+
+// Setup makes a logger according to the configuration the default, which
+// the standard log package writes to as well
+func Setup( c *configuration.Config ) ( *Logging, error ) {
+    level, err := c.GetLogLevel()
+    if err != nil {
+        return nil, err
+    }
+    l := &Logging{
+        level: &slog.LevelVar{},
+    }
+    l.level.Set( level )
+
+    output := io.Writer( os.Stdout )
+    if len( c.LogFile ) >= 1 {
+        l.file, err = openFile( c.LogFile, rotation{} )
+        if err != nil {
+            return nil, err
+        }
+        output = l.file
+    }
+
+    switch c.AccessLog {
+    case "":
+    case "stdout":
+        l.access = os.Stdout
+    default:
+        l.accessFile, err = openFile( c.AccessLog, rotation{
+            maxSize: c.AccessLogMaxSize,
+            maxAge: c.AccessLogMaxAge,
+            maxBackups: c.AccessLogMaxBackups,
+        })
+        if err != nil {
+            l.Close()
+            return nil, err
+        }
+        l.access = l.accessFile
+    }
+
+    slog.SetDefault( slog.New( NewHandler( c.LogFormat, output, l.level ) ) )
+    return l, nil
+}
+
+
+// AccessLog returns where the access log is written to, nil if it is disabled
+func ( l *Logging ) AccessLog() io.Writer {
+    return l.access
+}
+
+
+// NewHandler makes a text or JSON handler which adds the attributes of the
+// context to every record
+func NewHandler( format string, output io.Writer, level slog.Leveler ) slog.Handler {
+    options := &slog.HandlerOptions{
+        Level: level,
+    }
+    if format == "json" {
+        return contextHandler{ slog.NewJSONHandler( output, options ) }
+    }
+    return contextHandler{ slog.NewTextHandler( output, options ) }
+}
+
+
+// Reload applies the log level and reopens the log file
+func ( l *Logging ) Reload( c *configuration.Config ) error {
+    level, err := c.GetLogLevel()
+    if err != nil {
+        return err
+    }
+    l.level.Set( level )
+
+    var errs []error
+    for _, file := range []*reopeningFile{ l.file, l.accessFile } {
+        if file != nil {
+            errs = append( errs, file.Reopen() )
+        }
+    }
+    return errors.Join( errs... )
+}
+
+
+func ( l *Logging ) Close() error {
+    var errs []error
+    for _, file := range []*reopeningFile{ l.file, l.accessFile } {
+        if file != nil {
+            errs = append( errs, file.Close() )
+        }
+    }
+    return errors.Join( errs... )
+}
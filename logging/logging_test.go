@@ -0,0 +1,90 @@
+package logging
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log/slog"
+    "os"
+    "testing"
+    fp "path/filepath"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func TestContextAttributes( t *testing.T ){
+    buffer := &bytes.Buffer{}
+    logger := slog.New( NewHandler( "json", buffer, slog.LevelInfo ) )
+
+    ctx := WithRequestId( context.Background(), "abc" )
+    ctx = With( ctx, slog.String( "method", "GET" ) )
+    logger.InfoContext( ctx, "Request handled", "status", 200 )
+    logger.DebugContext( ctx, "Not logged" )
+
+    record := map[ string ] any {}
+    assert.Nil( t, json.Unmarshal( buffer.Bytes(), &record ) )
+    assert.Equal( t, "Request handled", record[ "msg" ] )
+    assert.Equal( t, "abc", record[ "requestId" ] )
+    assert.Equal( t, "GET", record[ "method" ] )
+    assert.Equal( t, float64( 200 ), record[ "status" ] )
+    assert.Equal( t, "abc", RequestId( ctx ) )
+    assert.Equal( t, "", RequestId( context.Background() ) )
+}
+
+
+func TestReopeningFile( t *testing.T ){
+    path := fp.Join( t.TempDir(), "webservice.log" )
+    file, err := openFile( path, rotation{} )
+    assert.Nil( t, err )
+    defer file.Close()
+
+    _, err = file.Write( []byte( "first\n" ) )
+    assert.Nil( t, err )
+    assert.Nil( t, os.Rename( path, path + ".1" ) )
+    assert.Nil( t, file.Reopen() )
+    _, err = file.Write( []byte( "second\n" ) )
+    assert.Nil( t, err )
+
+    rotated, _ := os.ReadFile( path + ".1" )
+    current, _ := os.ReadFile( path )
+    assert.Equal( t, "first\n", string( rotated ) )
+    assert.Equal( t, "second\n", string( current ) )
+}
+
+
+func TestRotatingFile( t *testing.T ){
+    path := fp.Join( t.TempDir(), "access.log" )
+    file, err := openFile( path, rotation{ maxSize: 10, maxBackups: 2 } )
+    assert.Nil( t, err )
+    defer file.Close()
+
+    for _, line := range []string{ "first\n", "second\n", "third\n", "fourth\n" } {
+        _, err = file.Write( []byte( line ) )
+        assert.Nil( t, err )
+    }
+
+    current, _ := os.ReadFile( path )
+    assert.Equal( t, "fourth\n", string( current ) )
+    backups, _ := fp.Glob( path + ".*" )
+    assert.Len( t, backups, 2 )
+    oldest, _ := os.ReadFile( backups[ 0 ] )
+    assert.Equal( t, "second\n", string( oldest ) )
+}
+
+
+func TestRotationFailure( t *testing.T ){
+    dir := t.TempDir()
+    path := fp.Join( dir, "access.log" )
+    file, err := openFile( path, rotation{ maxSize: 10 } )
+    assert.Nil( t, err )
+    defer file.Close()
+
+    _, err = file.Write( []byte( "first\n" ) )
+    assert.Nil( t, err )
+    assert.Nil( t, os.RemoveAll( dir ) )
+
+    n, err := file.Write( []byte( "second\n" ) )
+    assert.Equal( t, 7, n )
+    assert.ErrorContains( t, err, "Log file " + path + " not able to be rotated" )
+}
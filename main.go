@@ -2,40 +2,72 @@ package main
 
 import (
     "context"
+    "errors"
+    "flag"
     "fmt"
-    "log"
     "log/slog"
     "os"
     "os/signal"
+    "sync/atomic"
     "syscall"
     "time"
 
+    "webservice/backup"
     "webservice/configuration"
+    "webservice/health"
+    "webservice/logging"
+    "webservice/metrics"
     "webservice/routing"
     "webservice/state"
+    "webservice/tracing"
 
     "github.com/gofiber/fiber/v2"
 )
 
 
 func main() {
-    config, err := configuration.New()
+    if len( os.Args ) >= 2 {
+        switch os.Args[ 1 ] {
+        case "migrate":
+            os.Exit( runMigrate( os.Args[ 2: ] ) )
+        case "state":
+            os.Exit( runState( os.Args[ 2: ] ) )
+        case "config":
+            os.Exit( runConfig( os.Args[ 2: ] ) )
+        }
+    }
+
+    flags := flag.NewFlagSet( "webservice", flag.ContinueOnError )
+    sources := configuration.BindFlags( flags )
+    if err := flags.Parse( os.Args[ 1: ] ); err != nil {
+        if errors.Is( err, flag.ErrHelp ) {
+            os.Exit( 0 )
+        }
+        os.Exit( 2 )
+    }
+
+    config, err := sources.Load()
     if err != nil {
         slog.Error( fmt.Sprintf( "HTTP server failed to start: %v", err ) )
         os.Exit( 1 )
     }
 
-    level, _ := config.GetLogLevel()
-    slog.SetDefault(
-        slog.New(
-            slog.NewTextHandler(
-                os.Stdout,
-                &slog.HandlerOptions{
-                    Level: level,
-                },
-            ),
-        ),
-    )
+    logs, err := logging.Setup( config )
+    if err != nil {
+        slog.Error( fmt.Sprintf( "HTTP server failed to start: %v", err ) )
+        os.Exit( 1 )
+    }
+    defer logs.Close()
+
+    // registered before startup, so early signals do not terminate the
+    // process and are handled once startup completes
+    reloading := make( chan os.Signal, 1 )
+    signal.Notify( reloading, syscall.SIGHUP )
+
+    osSignaling := make( chan os.Signal, 1 )
+    signal.Notify( osSignaling, syscall.SIGINT  )
+    signal.Notify( osSignaling, syscall.SIGTERM )
+    signal.Notify( osSignaling, syscall.SIGQUIT )
 
     server := fiber.New( fiber.Config{
         AppName: "webservice",
@@ -43,16 +75,85 @@ func main() {
         BodyLimit: configuration.BODY_SIZE_LIMIT,
     })
 
+    if access := logs.AccessLog(); access != nil {
+        accessLogging, err := routing.AccessLogging( config, access )
+        if err != nil {
+            slog.Error( fmt.Sprintf( "HTTP server failed to start: %v", err ) )
+            os.Exit( 1 )
+        }
+        server.Use( accessLogging )
+    }
+
     var store state.Store
-    if len( config.DatabaseHost ) <= 0 {
+    var persistent *state.Persistent
+    if ! config.HasDatabase() {
         store = state.NewEphemeralStore()
     } else {
-        store = state.NewPersistentStore( config )
+        persistent = state.NewPersistentStore( config )
+        store = persistent
+    }
+    if config.WriteBehindEnabled {
+        store = state.NewWriteBehindStore( store, config )
+    }
+    if config.CacheEnabled {
+        store = state.NewCachedStore( store, config )
     }
 
-    var isHealthy = false
+    storeBackend := "ephemeral"
+    if config.HasDatabase() {
+        storeBackend = "redis"
+    }
+    registry := metrics.New()
+    tracer := tracing.NewTracer( config )
+    tracer.Start()
+    store = state.NewObservedStore(
+        store,
+        registry.StoreObserver( storeBackend ),
+        tracing.StoreObserver( storeBackend ),
+    )
+    registry.RegisterStore( store )
 
-    err = routing.SetRoutes( server, config, store, &isHealthy )
+    checker := health.NewChecker( config.HealthCheckInterval )
+    checker.Register(
+        "store:responseTime",
+        health.StoreProbe( store, storeBackend, config.HealthWarnLatency ),
+    )
+    checker.Register( "uptime", health.UptimeProbe( time.Now() ) )
+
+    startupCheck := atomic.Pointer[ health.Check ]{}
+    startupCheck.Store( &health.Check{
+        ComponentId: storeBackend,
+        ComponentType: "datastore",
+        Status: health.StatusWarn,
+        Output: "Store verification in progress",
+    })
+    checker.Register( "store:startup", func() health.Check {
+        return *startupCheck.Load()
+    })
+    checker.Start()
+
+    lifecycle := health.NewLifecycle()
+    listening := make( chan struct{} )
+    server.Hooks().OnListen( func( _ fiber.ListenData ) error {
+        close( listening )
+        return nil
+    })
+
+    var backups *backup.Scheduler
+    if len( config.BackupDir ) >= 1 {
+        backups = backup.NewScheduler( store, config )
+        checker.Register( "backup", backups.Check )
+        registry.Register( backups )
+        backups.Start()
+    }
+
+    var exporter *metrics.Exporter
+    if len( config.OTLPEndpoint ) >= 1 {
+        exporter = metrics.NewExporter( registry, config )
+        exporter.Start()
+    }
+
+    err = routing.SetRoutes( server, config, store, lifecycle, checker, backups, registry, tracer )
     if err != nil {
         slog.Error( fmt.Sprintf( "HTTP server failed to start: %v", err ) )
         os.Exit( 1 )
@@ -66,36 +167,137 @@ func main() {
         }
     }()
 
-    osSignaling := make( chan os.Signal, 1 )
-    signal.Notify( osSignaling, syscall.SIGHUP  )
-    signal.Notify( osSignaling, syscall.SIGINT  )
-    signal.Notify( osSignaling, syscall.SIGTERM )
-    signal.Notify( osSignaling, syscall.SIGQUIT )
+    var adminServer *fiber.App
+    if config.AdminPort >= 1 {
+        adminServer = fiber.New( fiber.Config{
+            AppName: "webservice admin",
+            DisableStartupMessage: true,
+        })
+        routing.SetAdminRoutes( adminServer, config, backups )
+        go func(){
+            err := adminServer.Listen( fmt.Sprintf( "%s:%d", config.AdminHost, config.AdminPort ) )
+            if err != nil {
+                slog.Error( fmt.Sprintf( "HTTP admin server failed to start: %v", err ) )
+                os.Exit( 1 )
+            }
+        }()
+    }
+
+    verifyStore := func( ctx context.Context ) error {
+        attempts, err := state.Await( ctx, store, config.DatabaseStartupBackoff )
+        check := &health.Check{
+            ComponentId: storeBackend,
+            ComponentType: "datastore",
+            ObservedValue: attempts,
+            ObservedUnit: "attempts",
+            Status: health.StatusPass,
+            Time: time.Now().UTC().Format( time.RFC3339 ),
+        }
+        if err != nil {
+            check.Status = health.StatusWarn
+            check.Output = err.Error()
+        }
+        startupCheck.Store( check )
+        checker.Run()
+        return err
+    }
+
+    verifying, concludeVerification := context.WithTimeout(
+        context.Background(),
+        config.DatabaseStartupTimeout,
+    )
+    err = verifyStore( verifying )
+    concludeVerification()
+    if err != nil {
+        if ! config.DatabaseStartupDegraded {
+            slog.Error( fmt.Sprintf( "HTTP server failed to start: %v", err ) )
+            os.Exit( 1 )
+        }
+        slog.Warn( fmt.Sprintf( "HTTP server starts degraded: %v", err ) )
+
+        go func(){
+            _ = verifyStore( context.Background() )
+        }()
+    }
+
+    <-listening
+    _ = lifecycle.Ready()
+
+    reload := func(){
+        next, err := sources.Load()
+        if err != nil {
+            slog.Error( "Configuration reload rejected", "error", err )
+            return
+        }
+
+        applied, pending := config.Reload( next )
+        if err := logs.Reload( next ); err != nil {
+            slog.Error( "Logging not reloaded", "error", err )
+        }
+        for _, key := range applied {
+            slog.Info( "Configuration reloaded", "setting", key )
+        }
+        for _, key := range pending {
+            // reported at error level, as the configured value is not in effect
+            slog.Error( "Configuration changed, this requires a restart", "setting", key )
+        }
+
+        if persistent != nil {
+            if err := persistent.ReloadCredentials(); err != nil {
+                slog.Error( "Database credentials not reloaded", "error", err )
+            }
+        }
+    }
 
     shuttingDown := context.TODO()
-    isHealthy = true
     if config.Environment != "development" {
-        log.Println( "HTTP server started successfully" )
+        slog.Info( "HTTP server started successfully" )
     }
 
     for {
         select {
+        case <-reloading:
+            reload()
+
         case <-osSignaling:
-            isHealthy = false
-            log.Println( "Gracefully shutting down HTTP server" )
+            if lifecycle.Phase() >= health.PhaseDraining {
+                continue
+            }
+            _ = lifecycle.Drain()
+            slog.Info( "Gracefully shutting down HTTP server" )
+
+            select {
+            case <-time.After( config.PreStopDelay ):
+            case <-osSignaling:
+            }
+            _ = lifecycle.Stop()
 
             var concludeShutdown context.CancelFunc
             shuttingDown, concludeShutdown = context.WithTimeout(
                 context.Background(),
                 time.Second * 15,
             )
+            checker.Stop()
+            if backups != nil {
+                backups.Stop()
+            }
             err := server.ShutdownWithContext( shuttingDown )
             if err != nil {
-                log.Printf( "HTTP server failed to shut down: %v", err )
+                slog.Error( "HTTP server failed to shut down", "error", err )
+            }
+            if adminServer != nil {
+                err = adminServer.ShutdownWithContext( shuttingDown )
+                if err != nil {
+                    slog.Error( "HTTP admin server failed to shut down", "error", err )
+                }
+            }
+            if exporter != nil {
+                exporter.Stop()
             }
+            tracer.Stop()
             err = store.Disconnect()
             if err != nil {
-                log.Printf( "Store failed to disconnect: %v", err )
+                slog.Error( "Store failed to disconnect", "error", err )
             }
             concludeShutdown()
 
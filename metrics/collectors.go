@@ -0,0 +1,115 @@
+package metrics
+
+import (
+    "context"
+    "errors"
+    "sort"
+
+    "webservice/state"
+
+    "github.com/prometheus/client_golang/prometheus"
+)
+
+
+var (
+    entriesDesc = prometheus.NewDesc(
+        "state_entries_quantity",
+        "The current number of state entries being stored",
+        nil, nil,
+    )
+    bytesDesc = prometheus.NewDesc(
+        "state_entries_bytes",
+        "The current size of all state entries being stored, in bytes",
+        nil, nil,
+    )
+    mimeTypesDesc = prometheus.NewDesc(
+        "state_entries_mime_type_quantity",
+        "The current number of state entries being stored per media type",
+        []string{ "mime_type" }, nil,
+    )
+)
+
+
+// maxMimeTypes bounds the media types exposed, as they are chosen by
+// clients, the least common ones are summed up as other
+const maxMimeTypes = 50
+
+
+type entriesCollector struct {
+    store state.Store
+}
+
+
+func ( e entriesCollector ) Describe( descs chan<- *prometheus.Desc ) {
+    descs <- entriesDesc
+    descs <- bytesDesc
+    descs <- mimeTypesDesc
+}
+
+
+func ( e entriesCollector ) Collect( metrics chan<- prometheus.Metric ) {
+    stats, err := state.Count( context.Background(), e.store )
+    if errors.Is( err, state.ErrCounting ) {
+        return
+    }
+    if err != nil {
+        metrics <- prometheus.NewInvalidMetric( entriesDesc, err )
+        return
+    }
+    metrics <- prometheus.MustNewConstMetric( entriesDesc, prometheus.GaugeValue, float64( stats.Entries ) )
+    if stats.MimeTypes == nil {
+        return
+    }
+    metrics <- prometheus.MustNewConstMetric( bytesDesc, prometheus.GaugeValue, float64( stats.Bytes ) )
+
+    mimeTypes := make( []string, 0, len( stats.MimeTypes ) )
+    for t := range stats.MimeTypes {
+        mimeTypes = append( mimeTypes, t )
+    }
+    sort.Slice( mimeTypes, func( a int, b int ) bool {
+        if stats.MimeTypes[ mimeTypes[ a ] ] != stats.MimeTypes[ mimeTypes[ b ] ] {
+            return stats.MimeTypes[ mimeTypes[ a ] ] > stats.MimeTypes[ mimeTypes[ b ] ]
+        }
+        return mimeTypes[ a ] < mimeTypes[ b ]
+    })
+
+    other := int64( 0 )
+    for i, t := range mimeTypes {
+        if i >= maxMimeTypes {
+            other += stats.MimeTypes[ t ]
+            continue
+        }
+        metrics <- prometheus.MustNewConstMetric(
+            mimeTypesDesc, prometheus.GaugeValue, float64( stats.MimeTypes[ t ] ), t,
+        )
+    }
+    if other > 0 {
+        metrics <- prometheus.MustNewConstMetric( mimeTypesDesc, prometheus.GaugeValue, float64( other ), "other" )
+    }
+}
+
+
+// instrumentedCollector exposes the metrics a component reports itself,
+// which are only known once they are collected
+type instrumentedCollector struct {
+    source state.Instrumented
+}
+
+
+func ( i instrumentedCollector ) Describe( descs chan<- *prometheus.Desc ) {}
+
+
+func ( i instrumentedCollector ) Collect( metrics chan<- prometheus.Metric ) {
+    valueTypes := map[ string ] prometheus.ValueType {
+        "counter":  prometheus.CounterValue,
+        "gauge":    prometheus.GaugeValue,
+    }
+    for _, metric := range i.source.Metrics() {
+        valueType, found := valueTypes[ metric.Type ]
+        if !found {
+            valueType = prometheus.UntypedValue
+        }
+        desc := prometheus.NewDesc( metric.Name, metric.Help, nil, nil )
+        metrics <- prometheus.MustNewConstMetric( desc, valueType, metric.Value )
+    }
+}
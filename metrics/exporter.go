@@ -0,0 +1,80 @@
+package metrics
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "time"
+    log "log/slog"
+
+    "webservice/configuration"
+    "webservice/otlp"
+)
+
+
+// Exporter pushes the metrics of a registry to an OTLP/HTTP collector
+type Exporter struct {
+    registry    *Registry
+    resource    otlp.Resource
+    client      *otlp.Client
+    interval    time.Duration
+
+    stop        chan struct{}
+    stopped     chan struct{}
+    stopping    sync.Once
+}
+
+
+func NewExporter( r *Registry, c *configuration.Config ) *Exporter {
+    return &Exporter{
+        registry: r,
+        resource: otlp.ResourceOf( c ),
+        client: otlp.NewClient( c ),
+        interval: c.OTLPInterval,
+        stop: make( chan struct{} ),
+        stopped: make( chan struct{} ),
+    }
+}
+
+
+func ( e *Exporter ) Start() {
+    go func(){
+        defer close( e.stopped )
+        ticker := time.NewTicker( e.interval )
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                e.export()
+            case <-e.stop:
+                return
+            }
+        }
+    }()
+}
+
+
+// Stop exports the metrics one last time, so that the collector does not
+// miss what happened since the previous export
+func ( e *Exporter ) Stop() {
+    e.stopping.Do( func(){
+        close( e.stop )
+        <-e.stopped
+        e.export()
+    })
+}
+
+
+func ( e *Exporter ) export() {
+    if err := e.Export( context.Background() ); err != nil {
+        log.Error( "Metrics export failed", "endpoint", e.client.Endpoint(), "error", err )
+    }
+}
+
+
+// Export pushes the metrics once, the ones gathered are pushed even if some
+// collectors failed
+func ( e *Exporter ) Export( ctx context.Context ) error {
+    exported, err := e.registry.gatherOTLP( e.resource )
+    return errors.Join( err, e.client.Export( ctx, "metrics", exported ) )
+}
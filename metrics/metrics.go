@@ -0,0 +1,134 @@
+package metrics
+
+import (
+    "context"
+    "net/http"
+    "strconv"
+    "time"
+
+    "webservice/state"
+
+    "github.com/prometheus/client_golang/prometheus"
+    "github.com/prometheus/client_golang/prometheus/collectors"
+    "github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+
+var sizeBuckets = prometheus.ExponentialBuckets( 64, 4, 10 )
+
+
+type Registry struct {
+    registry    *prometheus.Registry
+    started     time.Time
+
+    requests        *prometheus.CounterVec
+    durations       *prometheus.HistogramVec
+    requestSizes    *prometheus.HistogramVec
+    responseSizes   *prometheus.HistogramVec
+
+    operations      *prometheus.HistogramVec
+    operationErrors *prometheus.CounterVec
+}
+
+
+// New makes a registry with the HTTP, store, Go runtime and process metrics
+func New() *Registry {
+    r := &Registry{
+        registry: prometheus.NewRegistry(),
+        started: time.Now(),
+        requests: prometheus.NewCounterVec( prometheus.CounterOpts{
+            Name: "http_requests_total",
+            Help: "The number of HTTP requests handled",
+        }, []string{ "method", "route", "status" } ),
+        durations: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+            Name: "http_request_duration_seconds",
+            Help: "The time taken to handle HTTP requests, in seconds",
+            Buckets: prometheus.DefBuckets,
+        }, []string{ "method", "route", "status" } ),
+        requestSizes: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+            Name: "http_request_size_bytes",
+            Help: "The size of HTTP request bodies, in bytes",
+            Buckets: sizeBuckets,
+        }, []string{ "method", "route" } ),
+        responseSizes: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+            Name: "http_response_size_bytes",
+            Help: "The size of HTTP response bodies, in bytes",
+            Buckets: sizeBuckets,
+        }, []string{ "method", "route" } ),
+        operations: prometheus.NewHistogramVec( prometheus.HistogramOpts{
+            Name: "state_operation_duration_seconds",
+            Help: "The time taken by store operations, in seconds",
+            Buckets: prometheus.DefBuckets,
+        }, []string{ "backend", "operation" } ),
+        operationErrors: prometheus.NewCounterVec( prometheus.CounterOpts{
+            Name: "state_operation_errors_total",
+            Help: "The number of store operations failed",
+        }, []string{ "backend", "operation" } ),
+    }
+
+    r.registry.MustRegister(
+        collectors.NewGoCollector(),
+        collectors.NewProcessCollector( collectors.ProcessCollectorOpts{} ),
+        r.requests,
+        r.durations,
+        r.requestSizes,
+        r.responseSizes,
+        r.operations,
+        r.operationErrors,
+    )
+    return r
+}
+
+
+// Register exposes the metrics a component reports itself
+func ( r *Registry ) Register( source state.Instrumented ) {
+    r.registry.MustRegister( instrumentedCollector{ source } )
+}
+
+
+// RegisterStore exposes the number of entries and the metrics the store
+// reports itself
+func ( r *Registry ) RegisterStore( store state.Store ) {
+    r.registry.MustRegister( entriesCollector{ store } )
+    if instrumented, ok := store.( state.Instrumented ); ok {
+        r.Register( instrumented )
+    }
+}
+
+
+func ( r *Registry ) ObserveRequest(
+    method string,
+    route string,
+    status int,
+    duration time.Duration,
+    requestSize int,
+    responseSize int,
+) {
+    code := strconv.Itoa( status )
+    r.requests.WithLabelValues( method, route, code ).Inc()
+    r.durations.WithLabelValues( method, route, code ).Observe( duration.Seconds() )
+    r.requestSizes.WithLabelValues( method, route ).Observe( float64( requestSize ) )
+    r.responseSizes.WithLabelValues( method, route ).Observe( float64( responseSize ) )
+}
+
+
+func ( r *Registry ) StoreObserver( backend string ) state.Observer {
+    return func( ctx context.Context, operation string ) ( context.Context, func( error ) ) {
+        started := time.Now()
+        return ctx, func( err error ){
+            r.operations.WithLabelValues( backend, operation ).Observe( time.Since( started ).Seconds() )
+            if err != nil {
+                r.operationErrors.WithLabelValues( backend, operation ).Inc()
+            }
+        }
+    }
+}
+
+
+// Handler serves the metrics in the Prometheus text or the OpenMetrics
+// format, depending on the Accept header
+func ( r *Registry ) Handler() http.Handler {
+    return promhttp.HandlerFor( r.registry, promhttp.HandlerOpts{
+        EnableOpenMetrics: true,
+    })
+}
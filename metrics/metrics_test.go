@@ -0,0 +1,245 @@
+package metrics
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    ht "net/http/httptest"
+    "testing"
+    "time"
+
+    "webservice/configuration"
+    "webservice/state"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+type reporting struct{}
+
+func ( reporting ) Metrics() []state.Metric {
+    return []state.Metric{
+        { Name: "reported_total", Help: "Reported by the component", Type: "counter", Value: 3 },
+        { Name: "reported_bytes", Help: "Reported by the component", Type: "gauge", Value: 42 },
+    }
+}
+
+
+type uncounted struct {
+    *state.Ephemeral
+    failing bool
+}
+
+func ( uncounted ) Stats( ctx context.Context ) ( state.Stats, error ) {
+    return state.Stats{}, state.ErrNotCounted
+}
+
+func ( u uncounted ) List( ctx context.Context ) ( []string, error ) {
+    if u.failing {
+        return nil, errors.New( "backend unreachable" )
+    }
+    return u.Ephemeral.List( ctx )
+}
+
+
+func scrape( t *testing.T, r *Registry ) ( int, string ) {
+    res := ht.NewRecorder()
+    r.Handler().ServeHTTP( res, ht.NewRequest( "GET", "/metrics", nil ) )
+    body, err := io.ReadAll( res.Body )
+    assert.Nil( t, err )
+    return res.Code, string( body )
+}
+
+
+func TestRegister( t *testing.T ){
+    r := New()
+    r.Register( reporting{} )
+    store := state.NewEphemeralStore()
+    ctx := context.Background()
+    assert.Nil( t, store.Add( ctx, state.NewItem( "foo", "text/plain", []byte( "bar" ) ) ) )
+    assert.Nil( t, store.Add( ctx, state.NewItem( "baz", "Text/Plain; charset=utf-8", []byte( "qux" ) ) ) )
+    assert.Nil( t, store.Add( ctx, state.NewItem( "raw", "application/octet-stream", []byte{ 1 } ) ) )
+    r.RegisterStore( store )
+
+    status, body := scrape( t, r )
+    assert.Equal( t, http.StatusOK, status )
+    assert.Contains( t, body, "# TYPE reported_total counter\nreported_total 3\n" )
+    assert.Contains( t, body, "# TYPE reported_bytes gauge\nreported_bytes 42\n" )
+    assert.Contains( t, body, "state_entries_quantity 3\n" )
+    assert.Contains( t, body, "state_entries_bytes 7\n" )
+    assert.Contains( t, body, `state_entries_mime_type_quantity{mime_type="text/plain"} 2` )
+    assert.Contains( t, body, `state_entries_mime_type_quantity{mime_type="application/octet-stream"} 1` )
+    assert.Contains( t, body, "process_start_time_seconds" )
+
+    listed := New()
+    listed.RegisterStore( uncounted{ Ephemeral: store } )
+    status, body = scrape( t, listed )
+    assert.Equal( t, http.StatusOK, status )
+    assert.Contains( t, body, "state_entries_quantity 3\n" )
+    assert.NotContains( t, body, "state_entries_bytes" )
+
+    failing := New()
+    failing.RegisterStore( uncounted{ state.NewEphemeralStore(), true } )
+    status, _ = scrape( t, failing )
+    assert.Equal( t, http.StatusInternalServerError, status )
+}
+
+
+func TestStoreObserver( t *testing.T ){
+    r := New()
+    store := state.NewObservedStore( uncounted{ state.NewEphemeralStore(), true }, r.StoreObserver( "ephemeral" ) )
+
+    _, err := store.Fetch( context.Background(), "foo" )
+    assert.Nil( t, err )
+    _, err = store.List( context.Background() )
+    assert.NotNil( t, err )
+
+    _, body := scrape( t, r )
+    assert.Contains( t, body, `state_operation_duration_seconds_count{backend="ephemeral",operation="fetch"} 1` )
+    assert.Contains( t, body, `state_operation_errors_total{backend="ephemeral",operation="list"} 1` )
+    assert.NotContains( t, body, `state_operation_errors_total{backend="ephemeral",operation="fetch"}` )
+}
+
+
+// otlpMetricNamed looks up a metric in OTLP JSON, decoded without the types
+// of this package so that the encoding itself is verified
+func otlpMetricNamed( t *testing.T, body []byte, name string ) map[ string ] any {
+    exported := struct {
+        ResourceMetrics []struct {
+            ScopeMetrics []struct {
+                Metrics []map[ string ] any `json:"metrics"`
+            } `json:"scopeMetrics"`
+        } `json:"resourceMetrics"`
+    }{}
+    assert.Nil( t, json.Unmarshal( body, &exported ) )
+    for _, metric := range exported.ResourceMetrics[ 0 ].ScopeMetrics[ 0 ].Metrics {
+        if metric[ "name" ] == name {
+            return metric
+        }
+    }
+    t.Fatalf( "metric %s not exported", name )
+    return nil
+}
+
+
+func TestOTLPHandler( t *testing.T ){
+    t.Setenv( "ENV_NAME", "testing" )
+    config, err := configuration.New()
+    assert.Nil( t, err )
+
+    r := New()
+    r.Register( reporting{} )
+    r.ObserveRequest( "GET", "/state/:name", 200, time.Millisecond * 30, 5, 700 )
+
+    res := ht.NewRecorder()
+    r.OTLPHandler( config ).ServeHTTP( res, ht.NewRequest( "GET", "/metrics", nil ) )
+    assert.Equal( t, http.StatusOK, res.Code )
+    assert.Equal( t, "application/json", res.Header().Get( "Content-Type" ) )
+    body := res.Body.Bytes()
+
+    assert.Contains( t, string( body ), `"resource":{"attributes":[` +
+        `{"key":"service.name","value":{"stringValue":"webservice"}},` +
+        `{"key":"service.version","value":{"stringValue":"n/a"}},` +
+        `{"key":"deployment.environment","value":{"stringValue":"testing"}}]}` )
+
+    counter := otlpMetricNamed( t, body, "reported_total" )[ "sum" ].( map[ string ] any )
+    assert.Equal( t, true, counter[ "isMonotonic" ] )
+    assert.Equal( t, float64( 2 ), counter[ "aggregationTemporality" ] )
+    point := counter[ "dataPoints" ].( []any )[ 0 ].( map[ string ] any )
+    assert.Equal( t, float64( 3 ), point[ "asDouble" ] )
+    assert.NotEmpty( t, point[ "startTimeUnixNano" ] )
+
+    gauge := otlpMetricNamed( t, body, "reported_bytes" )[ "gauge" ].( map[ string ] any )
+    point = gauge[ "dataPoints" ].( []any )[ 0 ].( map[ string ] any )
+    assert.Equal( t, float64( 42 ), point[ "asDouble" ] )
+
+    histogram := otlpMetricNamed( t, body, "http_response_size_bytes" )[ "histogram" ].( map[ string ] any )
+    point = histogram[ "dataPoints" ].( []any )[ 0 ].( map[ string ] any )
+    assert.Equal( t, []any{
+        map[ string ] any { "key": "method", "value": map[ string ] any { "stringValue": "GET" } },
+        map[ string ] any { "key": "route", "value": map[ string ] any { "stringValue": "/state/:name" } },
+    }, point[ "attributes" ] )
+    assert.Equal( t, "1", point[ "count" ] )
+    assert.Equal( t, float64( 700 ), point[ "sum" ] )
+    bounds := point[ "explicitBounds" ].( []any )
+    buckets := point[ "bucketCounts" ].( []any )
+    assert.Equal( t, len( bounds ) + 1, len( buckets ) )
+    assert.Equal( t, []any{ float64( 64 ), float64( 256 ), float64( 1024 ) }, bounds[ :3 ] )
+    assert.Equal( t, []any{ "0", "0", "1", "0" }, buckets[ :4 ] )
+
+    failing := New()
+    failing.RegisterStore( uncounted{ state.NewEphemeralStore(), true } )
+    res = ht.NewRecorder()
+    failing.OTLPHandler( config ).ServeHTTP( res, ht.NewRequest( "GET", "/metrics", nil ) )
+    assert.Equal( t, http.StatusInternalServerError, res.Code )
+}
+
+
+type exported struct {
+    path    string
+    header  http.Header
+    body    []byte
+}
+
+
+// collector stands in for an OpenTelemetry collector receiving OTLP/HTTP
+func collector( t *testing.T, status int ) ( *ht.Server, <-chan exported ) {
+    received := make( chan exported, 16 )
+    server := ht.NewServer( http.HandlerFunc( func( w http.ResponseWriter, req *http.Request ){
+        body, err := io.ReadAll( req.Body )
+        assert.Nil( t, err )
+        select {
+        case received <- exported{ req.URL.Path, req.Header, body }:
+        default:
+        }
+        w.WriteHeader( status )
+    }))
+    t.Cleanup( server.Close )
+    return server, received
+}
+
+
+func TestExporter( t *testing.T ){
+    server, received := collector( t, http.StatusOK )
+    t.Setenv( "ENV_NAME", "testing" )
+    t.Setenv( "OTLP_ENDPOINT", server.URL + "/" )
+    t.Setenv( "OTLP_HEADERS", "Authorization=Bearer secret" )
+    t.Setenv( "OTLP_INTERVAL", "10ms" )
+    config, err := configuration.New()
+    assert.Nil( t, err )
+
+    r := New()
+    r.Register( reporting{} )
+    e := NewExporter( r, config )
+    e.Start()
+
+    select {
+    case export := <-received:
+        assert.Equal( t, "/v1/metrics", export.path )
+        assert.Equal( t, "application/json", export.header.Get( "Content-Type" ) )
+        assert.Equal( t, "Bearer secret", export.header.Get( "Authorization" ) )
+        sum := otlpMetricNamed( t, export.body, "reported_total" )[ "sum" ].( map[ string ] any )
+        assert.Equal( t, float64( 3 ), sum[ "dataPoints" ].( []any )[ 0 ].( map[ string ] any )[ "asDouble" ] )
+    case <-time.After( time.Second ):
+        t.Fatal( "metrics not exported" )
+    }
+
+    // stopping exports one last time
+    e.Stop()
+    e.Stop()
+    assert.GreaterOrEqual( t, len( received ), 1 )
+    for len( received ) >= 1 {
+        <-received
+    }
+    assert.Nil( t, e.Export( context.Background() ) )
+    assert.Equal( t, 1, len( received ) )
+
+    unavailable, _ := collector( t, http.StatusServiceUnavailable )
+    t.Setenv( "OTLP_ENDPOINT", unavailable.URL )
+    config, err = configuration.New()
+    assert.Nil( t, err )
+    err = NewExporter( r, config ).Export( context.Background() )
+    assert.ErrorContains( t, err, "status 503" )
+}
@@ -0,0 +1,250 @@
+package metrics
+
+import (
+    "encoding/json"
+    "math"
+    "net/http"
+    "strconv"
+    "time"
+
+    "webservice/configuration"
+    "webservice/otlp"
+
+    dto "github.com/prometheus/client_model/go"
+)
+
+
+// The types below follow the JSON encoding of OTLP metrics, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+
+const cumulativeTemporality = 2
+
+
+type otlpMetrics struct {
+    ResourceMetrics []otlpResourceMetrics   `json:"resourceMetrics"`
+}
+
+
+type otlpResourceMetrics struct {
+    Resource        otlp.Resource       `json:"resource"`
+    ScopeMetrics    []otlpScopeMetrics  `json:"scopeMetrics"`
+}
+
+
+type otlpScopeMetrics struct {
+    Scope       otlp.Scope      `json:"scope"`
+    Metrics     []otlpMetric    `json:"metrics"`
+}
+
+
+type otlpMetric struct {
+    Name        string          `json:"name"`
+    Description string          `json:"description,omitempty"`
+    Gauge       *otlpGauge      `json:"gauge,omitempty"`
+    Sum         *otlpSum        `json:"sum,omitempty"`
+    Histogram   *otlpHistogram  `json:"histogram,omitempty"`
+    Summary     *otlpSummary    `json:"summary,omitempty"`
+}
+
+
+type otlpGauge struct {
+    DataPoints  []otlpNumberPoint   `json:"dataPoints"`
+}
+
+
+type otlpSum struct {
+    DataPoints              []otlpNumberPoint   `json:"dataPoints"`
+    AggregationTemporality  int                 `json:"aggregationTemporality"`
+    IsMonotonic             bool                `json:"isMonotonic"`
+}
+
+
+type otlpHistogram struct {
+    DataPoints              []otlpHistogramPoint    `json:"dataPoints"`
+    AggregationTemporality  int                     `json:"aggregationTemporality"`
+}
+
+
+type otlpSummary struct {
+    DataPoints  []otlpSummaryPoint  `json:"dataPoints"`
+}
+
+
+type otlpNumberPoint struct {
+    Attributes          []otlp.Attribute    `json:"attributes,omitempty"`
+    StartTimeUnixNano   string              `json:"startTimeUnixNano,omitempty"`
+    TimeUnixNano        string              `json:"timeUnixNano"`
+    AsDouble            otlp.Double         `json:"asDouble"`
+}
+
+
+type otlpHistogramPoint struct {
+    Attributes          []otlp.Attribute    `json:"attributes,omitempty"`
+    StartTimeUnixNano   string              `json:"startTimeUnixNano"`
+    TimeUnixNano        string              `json:"timeUnixNano"`
+    Count               string              `json:"count"`
+    Sum                 otlp.Double         `json:"sum"`
+    BucketCounts        []string            `json:"bucketCounts"`
+    ExplicitBounds      []otlp.Double       `json:"explicitBounds"`
+}
+
+
+type otlpSummaryPoint struct {
+    Attributes          []otlp.Attribute    `json:"attributes,omitempty"`
+    StartTimeUnixNano   string              `json:"startTimeUnixNano"`
+    TimeUnixNano        string              `json:"timeUnixNano"`
+    Count               string              `json:"count"`
+    Sum                 otlp.Double         `json:"sum"`
+    QuantileValues      []otlpQuantile      `json:"quantileValues"`
+}
+
+
+type otlpQuantile struct {
+    Quantile    otlp.Double `json:"quantile"`
+    Value       otlp.Double `json:"value"`
+}
+
+
+// gatherOTLP gathers all metrics, as much as gathered is returned along with an
+// error if some collectors failed
+func ( r *Registry ) gatherOTLP( resource otlp.Resource ) ( otlpMetrics, error ) {
+    families, err := r.registry.Gather()
+
+    now := otlp.UnixNano( time.Now() )
+    started := otlp.UnixNano( r.started )
+    metrics := make( []otlpMetric, 0, len( families ) )
+    for _, family := range families {
+        metric := otlpMetric{
+            Name: family.GetName(),
+            Description: family.GetHelp(),
+        }
+
+        switch family.GetType() {
+        case dto.MetricType_COUNTER:
+            metric.Sum = &otlpSum{
+                AggregationTemporality: cumulativeTemporality,
+                IsMonotonic: true,
+            }
+            for _, m := range family.GetMetric() {
+                metric.Sum.DataPoints = append( metric.Sum.DataPoints, otlpNumberPoint{
+                    Attributes: attributesOf( m ),
+                    StartTimeUnixNano: started,
+                    TimeUnixNano: now,
+                    AsDouble: otlp.Double( m.GetCounter().GetValue() ),
+                })
+            }
+
+        case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+            metric.Gauge = &otlpGauge{}
+            for _, m := range family.GetMetric() {
+                value := m.GetGauge().GetValue()
+                if family.GetType() == dto.MetricType_UNTYPED {
+                    value = m.GetUntyped().GetValue()
+                }
+                metric.Gauge.DataPoints = append( metric.Gauge.DataPoints, otlpNumberPoint{
+                    Attributes: attributesOf( m ),
+                    TimeUnixNano: now,
+                    AsDouble: otlp.Double( value ),
+                })
+            }
+
+        case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+            metric.Histogram = &otlpHistogram{
+                AggregationTemporality: cumulativeTemporality,
+            }
+            for _, m := range family.GetMetric() {
+                point := otlpHistogramPoint{
+                    Attributes: attributesOf( m ),
+                    StartTimeUnixNano: started,
+                    TimeUnixNano: now,
+                    Count: strconv.FormatUint( m.GetHistogram().GetSampleCount(), 10 ),
+                    Sum: otlp.Double( m.GetHistogram().GetSampleSum() ),
+                }
+                // Prometheus buckets are cumulative, OTLP ones are not and
+                // the bucket above the last bound is implicit
+                below := uint64( 0 )
+                for _, bucket := range m.GetHistogram().GetBucket() {
+                    if math.IsInf( bucket.GetUpperBound(), 1 ) {
+                        continue
+                    }
+                    point.ExplicitBounds = append( point.ExplicitBounds, otlp.Double( bucket.GetUpperBound() ) )
+                    point.BucketCounts = append(
+                        point.BucketCounts,
+                        strconv.FormatUint( bucket.GetCumulativeCount() - below, 10 ),
+                    )
+                    below = bucket.GetCumulativeCount()
+                }
+                point.BucketCounts = append(
+                    point.BucketCounts,
+                    strconv.FormatUint( m.GetHistogram().GetSampleCount() - below, 10 ),
+                )
+                metric.Histogram.DataPoints = append( metric.Histogram.DataPoints, point )
+            }
+
+        case dto.MetricType_SUMMARY:
+            metric.Summary = &otlpSummary{}
+            for _, m := range family.GetMetric() {
+                point := otlpSummaryPoint{
+                    Attributes: attributesOf( m ),
+                    StartTimeUnixNano: started,
+                    TimeUnixNano: now,
+                    Count: strconv.FormatUint( m.GetSummary().GetSampleCount(), 10 ),
+                    Sum: otlp.Double( m.GetSummary().GetSampleSum() ),
+                    QuantileValues: []otlpQuantile{},
+                }
+                for _, quantile := range m.GetSummary().GetQuantile() {
+                    point.QuantileValues = append( point.QuantileValues, otlpQuantile{
+                        Quantile: otlp.Double( quantile.GetQuantile() ),
+                        Value: otlp.Double( quantile.GetValue() ),
+                    })
+                }
+                metric.Summary.DataPoints = append( metric.Summary.DataPoints, point )
+            }
+
+        default:
+            continue
+        }
+        metrics = append( metrics, metric )
+    }
+
+    return otlpMetrics{
+        ResourceMetrics: []otlpResourceMetrics{{
+            Resource: resource,
+            ScopeMetrics: []otlpScopeMetrics{{
+                Scope: otlp.Scope{ Name: "webservice" },
+                Metrics: metrics,
+            }},
+        }},
+    }, err
+}
+
+
+func attributesOf( m *dto.Metric ) []otlp.Attribute {
+    attributes := make( []otlp.Attribute, 0, len( m.GetLabel() ) )
+    for _, label := range m.GetLabel() {
+        attributes = append( attributes, otlp.String( label.GetName(), label.GetValue() ) )
+    }
+    return attributes
+}
+
+
+// OTLPHandler serves the metrics in the JSON encoding of OTLP, as they
+// would be pushed to a collector
+func ( r *Registry ) OTLPHandler( c *configuration.Config ) http.Handler {
+    resource := otlp.ResourceOf( c )
+    return http.HandlerFunc( func( w http.ResponseWriter, req *http.Request ){
+        exposed, err := r.gatherOTLP( resource )
+        if err != nil {
+            http.Error( w, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        body, err := json.Marshal( exposed )
+        if err != nil {
+            http.Error( w, err.Error(), http.StatusInternalServerError )
+            return
+        }
+        w.Header().Set( "Content-Type", "application/json" )
+        _, _ = w.Write( body )
+    })
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+
+    "webservice/backup"
+    "webservice/configuration"
+    "webservice/migration"
+    "webservice/state"
+)
+
+
+func openStore( spec string, base *configuration.Config ) ( state.Store, error ) {
+    if path, found := strings.CutPrefix( spec, "backup:" ); found {
+        items, err := backup.Read( path )
+        if err != nil {
+            return nil, err
+        }
+        store := state.NewEphemeralStore()
+        for _, item := range items {
+            if err := store.Add( context.Background(), item ); err != nil {
+                return nil, err
+            }
+        }
+        return store, nil
+    }
+
+    for _, scheme := range []string{ "redis://", "rediss://", "unix://" } {
+        if strings.HasPrefix( spec, scheme ) {
+            c := *base
+            c.DatabaseURL = spec
+            c.DatabaseHost = ""
+            c.DatabasePassword = ""
+            c.DatabaseSentinelMaster = ""
+            c.DatabaseSentinelAddresses = nil
+            c.DatabaseClusterAddresses = nil
+            return state.NewPersistentStore( &c ), nil
+        }
+    }
+
+    return nil, errors.New(
+        fmt.Sprintf( "Unsupported store: %s", spec ),
+    )
+}
+
+
+func runMigrate( args []string ) int {
+    flags := flag.NewFlagSet( "migrate", flag.ContinueOnError )
+    flags.Usage = func(){
+        fmt.Fprintln( flags.Output(), "Usage: artifact.bin migrate --from <store> --to <store> [options]" )
+        fmt.Fprintln( flags.Output(), "" )
+        fmt.Fprintln( flags.Output(), "A store is either a Redis URL (redis://, rediss://, unix://)" )
+        fmt.Fprintln( flags.Output(), "or a backup manifest (backup:<path>.manifest.json, source only)." )
+        fmt.Fprintln( flags.Output(), "" )
+        flags.PrintDefaults()
+    }
+    from := flags.String( "from", "", "store to copy entries from" )
+    to := flags.String( "to", "", "store to copy entries to" )
+    dryRun := flags.Bool( "dry-run", false, "only report what would be copied" )
+    conflicts := flags.String( "on-conflict", migration.ConflictFail, "what to do with differing existing entries: skip, overwrite or fail" )
+    checkpoint := flags.String( "checkpoint", "", "file recording copied entries, to resume an interrupted migration" )
+    quiet := flags.Bool( "quiet", false, "do not report progress" )
+
+    if err := flags.Parse( args ); err != nil {
+        return 2
+    }
+    if len( *from ) <= 0 || len( *to ) <= 0 || flags.NArg() >= 1 {
+        flags.Usage()
+        return 2
+    }
+    if strings.HasPrefix( *to, "backup:" ) {
+        fmt.Fprintln( os.Stderr, "Backups are only supported as source" )
+        return 2
+    }
+
+    config, err := configuration.New()
+    if err != nil {
+        fmt.Fprintf( os.Stderr, "Configuration invalid: %v\n", err )
+        return 1
+    }
+
+    source, err := openStore( *from, config )
+    if err != nil {
+        fmt.Fprintf( os.Stderr, "Source not available: %v\n", err )
+        return 1
+    }
+    defer source.Disconnect()
+
+    target, err := openStore( *to, config )
+    if err != nil {
+        fmt.Fprintf( os.Stderr, "Target not available: %v\n", err )
+        return 1
+    }
+    defer target.Disconnect()
+
+    lastReported := time.Time{}
+    report, err := migration.Run( source, target, migration.Options{
+        DryRun: *dryRun,
+        Conflicts: *conflicts,
+        Checkpoint: *checkpoint,
+        Progress: func( r migration.Report ){
+            if *quiet || time.Since( lastReported ) < time.Second && r.Processed < r.Total {
+                return
+            }
+            lastReported = time.Now()
+            fmt.Fprintf( os.Stderr, "Processed %d of %d entries\n", r.Processed, r.Total )
+        },
+    })
+
+    verb := "Copied"
+    if *dryRun {
+        verb = "Would copy"
+    }
+    fmt.Printf(
+        "%s %d, unchanged %d, resumed %d, skipped %d, vanished %d of %d entries\n",
+        verb, report.Copied, report.Unchanged, report.Resumed,
+        report.Skipped, report.Vanished, report.Total,
+    )
+    if err != nil {
+        fmt.Fprintf( os.Stderr, "Migration failed: %v\n", err )
+        return 1
+    }
+    return 0
+}
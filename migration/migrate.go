@@ -0,0 +1,212 @@
+package migration
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+
+    "webservice/state"
+)
+
+
+const (
+    ConflictSkip        = "skip"
+    ConflictOverwrite   = "overwrite"
+    ConflictFail        = "fail"
+)
+
+
+type Options struct {
+    DryRun      bool
+    Conflicts   string
+    Checkpoint  string
+    Progress    func( Report )
+}
+
+
+type Report struct {
+    Total       int     `json:"total"`
+    Processed   int     `json:"processed"`
+    Copied      int     `json:"copied"`
+    Unchanged   int     `json:"unchanged"`
+    Resumed     int     `json:"resumed"`
+    Skipped     int     `json:"skipped"`
+    Vanished    int     `json:"vanished"`
+}
+
+
+type checkpointRecord struct {
+    Name        string  `json:"name"`
+    Checksum    string  `json:"sha256"`
+}
+
+
+func readCheckpoint( path string ) ( map[ string ] string, error ) {
+    done := map[ string ] string {}
+    if len( path ) <= 0 {
+        return done, nil
+    }
+
+    file, err := os.Open( path )
+    if errors.Is( err, os.ErrNotExist ) {
+        return done, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner( file )
+    scanner.Buffer( make( []byte, 64 * 1024 ), 1024 * 1024 )
+    for scanner.Scan() {
+        record := checkpointRecord{}
+        if err := json.Unmarshal( scanner.Bytes(), &record ); err != nil {
+            // an interrupted run may leave a partially written last line
+            continue
+        }
+        done[ record.Name ] = record.Checksum
+    }
+    return done, scanner.Err()
+}
+
+
+func equal( a *state.Item, b *state.Item ) bool {
+    return a.MimeType() == b.MimeType() && a.Checksum() == b.Checksum()
+}
+
+
+func Run( from state.Store, to state.Store, options Options ) ( Report, error ) {
+    report := Report{}
+    ctx := context.Background()
+
+    possibleConflictPolicies := map[ string ] bool {
+        ConflictSkip:       true,
+        ConflictOverwrite:  true,
+        ConflictFail:       true,
+    }
+    if _, ok := possibleConflictPolicies[ options.Conflicts ]; !ok {
+        return report, errors.New(
+            fmt.Sprintf( "Invalid conflict policy: %s", options.Conflicts ),
+        )
+    }
+
+    done, err := readCheckpoint( options.Checkpoint )
+    if err != nil {
+        return report, err
+    }
+
+    var checkpoint *os.File
+    if len( options.Checkpoint ) >= 1 && ! options.DryRun {
+        checkpoint, err = os.OpenFile(
+            options.Checkpoint,
+            os.O_APPEND | os.O_CREATE | os.O_WRONLY,
+            0600,
+        )
+        if err != nil {
+            return report, err
+        }
+        defer checkpoint.Close()
+    }
+    record := func( item *state.Item ) error {
+        if checkpoint == nil {
+            return nil
+        }
+        line, err := json.Marshal( checkpointRecord{
+            Name: item.Name(),
+            Checksum: item.Checksum(),
+        })
+        if err != nil {
+            return err
+        }
+        _, err = checkpoint.Write( append( line, '\n' ) )
+        return err
+    }
+
+    names, err := from.List( ctx )
+    if err != nil {
+        return report, err
+    }
+    sort.Strings( names )
+    report.Total = len( names )
+
+    progress := func(){
+        report.Processed++
+        if options.Progress != nil {
+            options.Progress( report )
+        }
+    }
+
+    for _, name := range names {
+        item, err := from.Fetch( ctx, name )
+        if err != nil {
+            return report, err
+        }
+        if item == nil {
+            report.Vanished++
+            progress()
+            continue
+        }
+
+        if checksum, found := done[ name ]; found && checksum == item.Checksum() {
+            report.Resumed++
+            progress()
+            continue
+        }
+
+        existing, err := to.Fetch( ctx, name )
+        if err != nil {
+            return report, err
+        }
+        if existing != nil {
+            if equal( existing, item ) {
+                report.Unchanged++
+                if err := record( item ); err != nil {
+                    return report, err
+                }
+                progress()
+                continue
+            }
+
+            switch options.Conflicts {
+            case ConflictFail:
+                return report, errors.New(
+                    fmt.Sprintf( "Entry %s already exists with different content", name ),
+                )
+            case ConflictSkip:
+                report.Skipped++
+                progress()
+                continue
+            }
+        }
+
+        if options.DryRun {
+            report.Copied++
+            progress()
+            continue
+        }
+
+        if err := to.Add( ctx, *item ); err != nil {
+            return report, err
+        }
+        copied, err := to.Fetch( ctx, name )
+        if err != nil {
+            return report, err
+        }
+        if copied == nil || ! equal( copied, item ) {
+            return report, errors.New(
+                fmt.Sprintf( "Entry %s does not match its checksum after copying", name ),
+            )
+        }
+        if err := record( item ); err != nil {
+            return report, err
+        }
+        report.Copied++
+        progress()
+    }
+
+    return report, nil
+}
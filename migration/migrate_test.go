@@ -0,0 +1,101 @@
+package migration
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "testing"
+
+    "webservice/state"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+type limitedStore struct {
+    *state.Ephemeral
+    remaining   int
+    corrupt     bool
+}
+
+func ( s *limitedStore ) Add( ctx context.Context, i state.Item ) error {
+    if s.remaining <= 0 {
+        return errors.New( "target went away" )
+    }
+    s.remaining--
+    if s.corrupt {
+        i = state.NewItem( i.Name(), i.MimeType(), []byte( "corrupted" ) )
+    }
+    return s.Ephemeral.Add( ctx, i )
+}
+
+
+func newSource( count int ) *state.Ephemeral {
+    source := state.NewEphemeralStore()
+    for i := 0; i < count; i++ {
+        _ = source.Add( context.Background(), state.NewItem( fmt.Sprintf( "entry-%d", i ), "text/plain", []byte( fmt.Sprint( i ) ) ) )
+    }
+    return source
+}
+
+
+func TestRun( t *testing.T ){
+    source := newSource( 5 )
+    target := state.NewEphemeralStore()
+
+    reports := 0
+    report, err := Run( source, target, Options{
+        DryRun: true,
+        Conflicts: ConflictFail,
+        Progress: func( Report ){ reports++ },
+    })
+    assert.Nil( t, err )
+    assert.Equal( t, 5, report.Copied )
+    assert.Equal( t, 5, reports )
+    names, _ := target.List( context.Background() )
+    assert.Empty( t, names )
+
+    _ = target.Add( context.Background(), state.NewItem( "entry-0", "text/plain", []byte( "0" ) ) )
+    _ = target.Add( context.Background(), state.NewItem( "entry-1", "text/plain", []byte( "different" ) ) )
+
+    _, err = Run( source, target, Options{ Conflicts: "ignore" } )
+    assert.NotNil( t, err )
+
+    _, err = Run( source, target, Options{ Conflicts: ConflictFail } )
+    assert.NotNil( t, err )
+
+    report, err = Run( source, target, Options{ Conflicts: ConflictSkip } )
+    assert.Nil( t, err )
+    assert.Equal( t, Report{ Total: 5, Processed: 5, Copied: 3, Unchanged: 1, Skipped: 1 }, report )
+    kept, _ := target.Fetch( context.Background(), "entry-1" )
+    assert.Equal( t, []byte( "different" ), kept.Data() )
+
+    report, err = Run( source, target, Options{ Conflicts: ConflictOverwrite } )
+    assert.Nil( t, err )
+    assert.Equal( t, 1, report.Copied )
+    assert.Equal( t, 4, report.Unchanged )
+    overwritten, _ := target.Fetch( context.Background(), "entry-1" )
+    assert.Equal( t, []byte( "1" ), overwritten.Data() )
+}
+
+
+func TestRunResume( t *testing.T ){
+    source := newSource( 10 )
+    checkpoint := t.TempDir() + "/checkpoint"
+
+    target := &limitedStore{ Ephemeral: state.NewEphemeralStore(), remaining: 4 }
+    report, err := Run( source, target, Options{ Conflicts: ConflictFail, Checkpoint: checkpoint } )
+    assert.NotNil( t, err )
+    assert.Equal( t, 4, report.Copied )
+
+    target.remaining = 100
+    report, err = Run( source, target, Options{ Conflicts: ConflictFail, Checkpoint: checkpoint } )
+    assert.Nil( t, err )
+    assert.Equal( t, 4, report.Resumed )
+    assert.Equal( t, 6, report.Copied )
+
+    corrupting := &limitedStore{ Ephemeral: state.NewEphemeralStore(), remaining: 100, corrupt: true }
+    _, err = Run( source, corrupting, Options{ Conflicts: ConflictFail } )
+    assert.NotNil( t, err )
+    assert.Contains( t, err.Error(), "checksum" )
+}
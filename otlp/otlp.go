@@ -0,0 +1,161 @@
+package otlp
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "webservice/configuration"
+)
+
+
+// The types below follow the JSON encoding of OTLP shared by all signals, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+// 64 bit integers are encoded as strings, as protobuf does in JSON
+
+
+type Resource struct {
+    Attributes  []Attribute `json:"attributes"`
+}
+
+
+type Scope struct {
+    Name        string  `json:"name"`
+    Version     string  `json:"version,omitempty"`
+}
+
+
+type Attribute struct {
+    Key     string  `json:"key"`
+    Value   Value   `json:"value"`
+}
+
+
+type Value struct {
+    StringValue *string `json:"stringValue,omitempty"`
+    IntValue    *string `json:"intValue,omitempty"`
+    BoolValue   *bool   `json:"boolValue,omitempty"`
+}
+
+
+func String( key string, value string ) Attribute {
+    return Attribute{ Key: key, Value: Value{ StringValue: &value } }
+}
+
+
+func Int( key string, value int64 ) Attribute {
+    encoded := strconv.FormatInt( value, 10 )
+    return Attribute{ Key: key, Value: Value{ IntValue: &encoded } }
+}
+
+
+func Bool( key string, value bool ) Attribute {
+    return Attribute{ Key: key, Value: Value{ BoolValue: &value } }
+}
+
+
+// Double encodes the special values JSON lacks the way protobuf does
+type Double float64
+
+func ( d Double ) MarshalJSON() ( []byte, error ) {
+    switch {
+    case math.IsNaN( float64( d ) ):
+        return []byte( `"NaN"` ), nil
+    case math.IsInf( float64( d ), 1 ):
+        return []byte( `"Infinity"` ), nil
+    case math.IsInf( float64( d ), -1 ):
+        return []byte( `"-Infinity"` ), nil
+    }
+    return json.Marshal( float64( d ) )
+}
+
+
+func UnixNano( t time.Time ) string {
+    return strconv.FormatInt( t.UnixNano(), 10 )
+}
+
+
+// ResourceOf describes the service the telemetry originates from
+func ResourceOf( c *configuration.Config ) Resource {
+    return Resource{
+        Attributes: []Attribute{
+            String( "service.name", c.ServiceId ),
+            String( "service.version", c.Version ),
+            String( "deployment.environment", c.Environment ),
+        },
+    }
+}
+
+
+// Client posts telemetry to an OTLP/HTTP collector
+type Client struct {
+    endpoint    string
+    headers     map[ string ] string
+    timeout     time.Duration
+    client      *http.Client
+}
+
+
+func NewClient( c *configuration.Config ) *Client {
+    // already validated as part of the configuration
+    headers, _ := c.GetOTLPHeaders()
+    return &Client{
+        endpoint: strings.TrimSuffix( c.OTLPEndpoint, "/" ),
+        headers: headers,
+        timeout: c.OTLPTimeout,
+        client: &http.Client{},
+    }
+}
+
+
+// Endpoint is the URL of the collector without credentials, to be logged
+func ( c *Client ) Endpoint() string {
+    if parsed, err := url.Parse( c.endpoint ); err == nil {
+        return parsed.Redacted()
+    }
+    return ""
+}
+
+
+// Export posts the JSON encoding of data for a signal, like metrics
+func ( c *Client ) Export( ctx context.Context, signal string, data any ) error {
+    body, err := json.Marshal( data )
+    if err != nil {
+        return err
+    }
+
+    ctx, cancel := context.WithTimeout( ctx, c.timeout )
+    defer cancel()
+    req, err := http.NewRequestWithContext(
+        ctx, http.MethodPost, c.endpoint + "/v1/" + signal, bytes.NewReader( body ),
+    )
+    if err != nil {
+        return err
+    }
+    req.Header.Set( "Content-Type", "application/json" )
+    for key, value := range c.headers {
+        req.Header.Set( key, value )
+    }
+
+    res, err := c.client.Do( req )
+    if err != nil {
+        return err
+    }
+    defer res.Body.Close()
+    _, _ = io.Copy( io.Discard, res.Body )
+    if res.StatusCode < 200 || res.StatusCode >= 300 {
+        return errors.New(
+            fmt.Sprintf( "Collector responded with status %d", res.StatusCode ),
+        )
+    }
+    return nil
+}
@@ -0,0 +1,147 @@
+package routing
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "sync"
+    "time"
+    log "log/slog"
+
+    "webservice/configuration"
+    "webservice/tracing"
+
+    f "github.com/gofiber/fiber/v2"
+)
+
+
+// accessRecord is what a custom access log template is able to refer to
+type accessRecord struct {
+    Time        time.Time       `json:"time"`
+    ClientIp    string          `json:"clientIp"`
+    Method      string          `json:"method"`
+    Path        string          `json:"path"`
+    Route       string          `json:"route"`
+    Protocol    string          `json:"protocol"`
+    Status      int             `json:"status"`
+    Bytes       int             `json:"bytes"`
+    Duration    time.Duration   `json:"-"`
+    Referer     string          `json:"referer,omitempty"`
+    Agent       string          `json:"agent,omitempty"`
+    RequestId   string          `json:"requestId"`
+    TraceId     string          `json:"traceId,omitempty"`
+}
+
+
+type accessRecordJSON struct {
+    accessRecord
+    Seconds float64 `json:"duration"`
+}
+
+
+var probePaths = map[ string ] bool {
+    "/health":      true,
+    "/livez":       true,
+    "/readyz":      true,
+    "/startupz":    true,
+    "/metrics":     true,
+}
+
+
+func accessFormatter( config *configuration.Config ) ( func( accessRecord ) ( []byte, error ), error ) {
+    tmpl, err := config.GetAccessLogTemplate()
+    if err != nil {
+        return nil, err
+    }
+    if tmpl == nil {
+        return func( r accessRecord ) ( []byte, error ){
+            line, err := json.Marshal( accessRecordJSON{ r, r.Duration.Seconds() } )
+            return append( line, '\n' ), err
+        }, nil
+    }
+    return func( r accessRecord ) ( []byte, error ){
+        buffer := &bytes.Buffer{}
+        if err := tmpl.Execute( buffer, r ); err != nil {
+            return nil, err
+        }
+        if !bytes.HasSuffix( buffer.Bytes(), []byte{ '\n' } ) {
+            buffer.WriteByte( '\n' )
+        }
+        return buffer.Bytes(), nil
+    }, nil
+}
+
+
+// handled lets the error handler respond to an error, for middleware which
+// needs the final status of a request
+func handled( c *f.Ctx, err error ) {
+    if err == nil {
+        return
+    }
+    if err := c.App().ErrorHandler( c, err ); err != nil {
+        _ = c.SendStatus( f.StatusInternalServerError )
+    }
+}
+
+
+// responseSize measures the body sent, a streamed body only by the length
+// it announced
+func responseSize( c *f.Ctx ) int {
+    if c.Response().IsBodyStream() {
+        return max( c.Response().Header.ContentLength(), 0 )
+    }
+    if c.Method() == f.MethodHead {
+        return 0
+    }
+    return len( c.Response().Body() )
+}
+
+
+// AccessLogging writes a line per request in the configured format, it has
+// to be registered before any route to see the final status of a request
+func AccessLogging( config *configuration.Config, output io.Writer ) ( f.Handler, error ) {
+    format, err := accessFormatter( config )
+    if err != nil {
+        return nil, err
+    }
+    mux := sync.Mutex{}
+
+    return func( c *f.Ctx ) error {
+        started := time.Now()
+        handled( c, c.Next() )
+
+        status := c.Response().StatusCode()
+        if config.AccessLogSkipProbes && status < 400 && probePaths[ c.Path() ] {
+            return nil
+        }
+
+        traceId := ""
+        if trace := tracing.FromContext( c.UserContext() ).Context(); trace.IsValid() {
+            traceId = trace.TraceId.String()
+        }
+        line, err := format( accessRecord{
+            Time: started,
+            ClientIp: c.IP(),
+            Method: c.Method(),
+            Path: c.OriginalURL(),
+            Route: routeOf( c ),
+            Protocol: string( c.Request().Header.Protocol() ),
+            Status: status,
+            Bytes: responseSize( c ),
+            Duration: time.Since( started ),
+            Referer: c.Get( f.HeaderReferer ),
+            Agent: c.Get( f.HeaderUserAgent ),
+            RequestId: string( c.Response().Header.Peek( f.HeaderXRequestID ) ),
+            TraceId: traceId,
+        })
+        if err == nil {
+            mux.Lock()
+            _, err = output.Write( line )
+            mux.Unlock()
+        }
+        if err != nil {
+            log.ErrorContext( c.UserContext(), "Access log not able to be written", "error", err )
+        }
+        return nil
+    }, nil
+}
@@ -0,0 +1,100 @@
+package routing
+
+import (
+    "crypto/subtle"
+    "net/http"
+    "os"
+    "strings"
+    log "log/slog"
+
+    "webservice/backup"
+    "webservice/configuration"
+
+    f "github.com/gofiber/fiber/v2"
+)
+
+
+func adminGuard( config *configuration.Config ) f.Handler {
+    return func( c *f.Ctx ) error {
+        if len( config.AdminToken ) <= 0 {
+            if config.Environment == "production" {
+                return c.SendStatus( http.StatusForbidden )
+            }
+            return c.Next()
+        }
+
+        content, err := os.ReadFile( config.AdminToken )
+        if err != nil {
+            log.ErrorContext( c.UserContext(), "Admin token not able to be read", "error", err )
+            return c.SendStatus( http.StatusInternalServerError )
+        }
+        token := strings.TrimSpace( string( content ) )
+
+        presented, found := strings.CutPrefix( c.Get( f.HeaderAuthorization ), "Bearer " )
+        if !found || len( token ) <= 0 ||
+           subtle.ConstantTimeCompare( []byte( presented ), []byte( token ) ) != 1 {
+            c.Set( f.HeaderWWWAuthenticate, "Bearer" )
+            return c.SendStatus( http.StatusUnauthorized )
+        }
+        return c.Next()
+    }
+}
+
+
+func adminRoutes( router f.Router, backups *backup.Scheduler ) {
+    router.Get( "/backups", func( c *f.Ctx ) error {
+        if backups == nil {
+            return c.SendStatus( http.StatusNotFound )
+        }
+
+        manifests, err := backups.List()
+        if err != nil {
+            log.ErrorContext( c.UserContext(), "Backups not listed", "error", err )
+            return c.SendStatus( http.StatusInternalServerError )
+        }
+        last, lastSuccess := backups.Status()
+
+        return c.JSON( map[ string ] any {
+            "last": last,
+            "lastSuccess": lastSuccess,
+            "backups": manifests,
+        })
+    })
+
+
+    router.Post( "/backups", func( c *f.Ctx ) error {
+        if backups == nil {
+            return c.SendStatus( http.StatusNotFound )
+        }
+
+        status := backups.Run()
+        if len( status.Error ) >= 1 {
+            c.Status( http.StatusInternalServerError )
+        } else {
+            c.Status( http.StatusCreated )
+        }
+        return c.JSON( status )
+    })
+}
+
+
+// listenerGuard asks for the admin token on the admin listener if one is
+// configured, without one the listener is bound to a loopback interface
+func listenerGuard( config *configuration.Config ) f.Handler {
+    guard := adminGuard( config )
+    return func( c *f.Ctx ) error {
+        if len( config.AdminToken ) <= 0 {
+            return c.Next()
+        }
+        return guard( c )
+    }
+}
+
+
+// SetAdminRoutes serves the admin and debug endpoints on the admin listener
+func SetAdminRoutes( router *f.App, config *configuration.Config, backups *backup.Scheduler ) {
+    adminRoutes( router.Group( "/admin", listenerGuard( config ) ), backups )
+    debugRoutes( router.Group( "/debug", debugGuard( config ), listenerGuard( config ) ), config )
+
+    router.Use( fallback( http.StatusNotFound ) )
+}
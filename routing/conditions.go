@@ -0,0 +1,88 @@
+package routing
+
+import (
+    "errors"
+    "strings"
+
+    "webservice/state"
+
+    f "github.com/gofiber/fiber/v2"
+)
+
+
+var (
+    errPreconditionFailed = errors.New( "Precondition failed" )
+    errNotFound = errors.New( "Entry not found" )
+    errUnchanged = errors.New( "Entry not changed" )
+)
+
+
+func matchesEntityTags( header string, item *state.Item ) bool {
+    if item == nil {
+        return false
+    }
+    if strings.TrimSpace( header ) == "*" {
+        return true
+    }
+    for _, tag := range strings.Split( header, "," ) {
+        if sameEntityTag( tag, item.ETag() ) {
+            return true
+        }
+    }
+    return false
+}
+
+
+func preconditionFailed( ifMatch string, ifNoneMatch string, item *state.Item ) bool {
+    if len( ifMatch ) >= 1 && ! matchesEntityTags( ifMatch, item ) {
+        return true
+    }
+    if len( ifNoneMatch ) >= 1 && matchesEntityTags( ifNoneMatch, item ) {
+        return true
+    }
+    return false
+}
+
+
+// writeEntry writes item, or removes the entry if item is nil, once the
+// entry written before, which is returned, exists to be removed, meets the
+// preconditions of the request and is accepted by check if given; these are
+// checked by the store along the write if preconditions are given, as the
+// entry may be changed concurrently, otherwise the entry is fetched beforehand
+func writeEntry(
+    c *f.Ctx, store state.Store, name string, item *state.Item, check state.Condition,
+) ( *state.Item, error ) {
+    ifMatch, ifNoneMatch := c.Get( f.HeaderIfMatch ), c.Get( f.HeaderIfNoneMatch )
+
+    var existingItem *state.Item
+    condition := func( current *state.Item ) error {
+        existingItem = current
+        if item == nil && current == nil {
+            return errNotFound
+        }
+        if preconditionFailed( ifMatch, ifNoneMatch, current ) {
+            return errPreconditionFailed
+        }
+        if check != nil {
+            return check( current )
+        }
+        return nil
+    }
+
+    if len( ifMatch ) >= 1 || len( ifNoneMatch ) >= 1 {
+        err := store.Update( c.UserContext(), name, item, condition )
+        return existingItem, err
+    }
+
+    current, err := store.Fetch( c.UserContext(), name )
+    if err != nil {
+        return nil, err
+    }
+    if err = condition( current ); err != nil {
+        return existingItem, err
+    }
+    if item == nil {
+        return existingItem, store.Remove( c.UserContext(), name )
+    }
+    return existingItem, store.Add( c.UserContext(), *item )
+}
@@ -0,0 +1,52 @@
+package routing
+
+import (
+    "encoding/json"
+    "expvar"
+    "net/http"
+    "runtime"
+    "runtime/pprof"
+
+    "webservice/configuration"
+
+    f "github.com/gofiber/fiber/v2"
+    profiling "github.com/gofiber/fiber/v2/middleware/pprof"
+)
+
+
+// debugGuard disables the debug endpoints in production, like /env, unless
+// they are explicitly enabled
+func debugGuard( config *configuration.Config ) f.Handler {
+    return func( c *f.Ctx ) error {
+        if config.Environment == "production" && !config.DebugEnabled {
+            return c.SendStatus( http.StatusForbidden )
+        }
+        return c.Next()
+    }
+}
+
+
+func debugRoutes( router f.Router, config *configuration.Config ) {
+    router.Use( profiling.New() )
+
+
+    router.Get( "/goroutines", func( c *f.Ctx ) error {
+        c.Type( "txt", "utf-8" )
+        return pprof.Lookup( "goroutine" ).WriteTo( c, 2 )
+    })
+
+
+    router.Get( "/vars", func( c *f.Ctx ) error {
+        snapshot := map[ string ] any {
+            "goroutines": runtime.NumGoroutine(),
+            "gomaxprocs": runtime.GOMAXPROCS( 0 ),
+            "cpus": runtime.NumCPU(),
+            "goVersion": runtime.Version(),
+            "version": config.Version,
+        }
+        expvar.Do( func( v expvar.KeyValue ){
+            snapshot[ v.Key ] = json.RawMessage( v.Value.String() )
+        })
+        return c.JSON( snapshot )
+    })
+}
@@ -0,0 +1,97 @@
+package routing
+
+import (
+    "regexp"
+    "strings"
+    "sync"
+    log "log/slog"
+
+    "webservice/logging"
+
+    f "github.com/gofiber/fiber/v2"
+    "github.com/gofiber/fiber/v2/utils"
+)
+
+
+// routeValue resolves the route when a record is logged, as the route of a
+// request is only known once it reached its handler, and keeps it once the
+// request is handled, as fiber reuses the context for the next request
+type routeValue struct {
+    mux     sync.Mutex
+    c       *f.Ctx
+    route   string
+}
+
+func ( r *routeValue ) LogValue() log.Value {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+
+    if r.c != nil {
+        return log.StringValue( routeOf( r.c ) )
+    }
+    return log.StringValue( r.route )
+}
+
+func ( r *routeValue ) handled() {
+    r.mux.Lock()
+    defer r.mux.Unlock()
+
+    r.route = strings.Clone( routeOf( r.c ) )
+    r.c = nil
+}
+
+
+// requestIdPattern limits accepted ids to what is safe to echo and to log
+var requestIdPattern = regexp.MustCompile( `^[A-Za-z0-9._:+/=-]{1,128}$` )
+
+
+// requestId accepts the id a client or proxy assigned to a request or
+// generates one, and echoes it in the response
+func requestId() f.Handler {
+    return func( c *f.Ctx ) error {
+        id := c.Get( f.HeaderXRequestID )
+        if requestIdPattern.MatchString( id ) {
+            id = strings.Clone( id )
+        } else {
+            id = utils.UUIDv4()
+        }
+
+        c.Set( f.HeaderXRequestID, id )
+        c.SetUserContext( logging.WithRequestId( c.UserContext(), id ) )
+        return c.Next()
+    }
+}
+
+
+func requestLogging() f.Handler {
+    return func( c *f.Ctx ) error {
+        route := &routeValue{ c: c }
+        defer route.handled()
+
+        c.SetUserContext( logging.With(
+            c.UserContext(),
+            log.String( "method", c.Method() ),
+            log.Any( "route", route ),
+            log.String( "clientIp", strings.Clone( c.IP() ) ),
+        ))
+
+        err := c.Next()
+        log.DebugContext(
+            c.UserContext(), "Request handled",
+            "path", strings.Clone( c.Path() ),
+            "mime", strings.Clone( c.Get( f.HeaderContentType, c.Get( f.HeaderAccept, "" ) ) ),
+            "agent", strings.Clone( c.Get( f.HeaderUserAgent ) ),
+            "status", c.Response().StatusCode(),
+        )
+        return err
+    }
+}
+
+
+func storeFailed( c *f.Ctx, operation string, name string, err error ) {
+    attrs := []any{ "operation", operation, "error", err }
+    if len( name ) >= 1 {
+        attrs = append( attrs, "name", name )
+    }
+    log.ErrorContext( c.UserContext(), "Store operation failed", attrs... )
+}
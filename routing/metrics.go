@@ -0,0 +1,49 @@
+package routing
+
+import (
+    "strings"
+    "time"
+
+    "webservice/metrics"
+
+    f "github.com/gofiber/fiber/v2"
+)
+
+
+// unmatchedRoute labels requests no handler was registered for, which
+// would otherwise carry the path of the fallback answering them
+const unmatchedRoute = "unmatched"
+
+
+func fallback( status int ) f.Handler {
+    return func( c *f.Ctx ) error {
+        c.Locals( unmatchedRoute, true )
+        return c.SendStatus( status )
+    }
+}
+
+
+func routeOf( c *f.Ctx ) string {
+    if unmatched, _ := c.Locals( unmatchedRoute ).( bool ); unmatched {
+        return unmatchedRoute
+    }
+    return c.Route().Path
+}
+
+
+func requestMetrics( registry *metrics.Registry ) f.Handler {
+    return func( c *f.Ctx ) error {
+        started := time.Now()
+        handled( c, c.Next() )
+
+        registry.ObserveRequest(
+            strings.Clone( c.Method() ),
+            routeOf( c ),
+            c.Response().StatusCode(),
+            time.Since( started ),
+            len( c.Request().Body() ),
+            responseSize( c ),
+        )
+        return nil
+    }
+}
@@ -2,6 +2,7 @@ package routing
 
 import (
     "encoding/json"
+    "errors"
     "os"
     "fmt"
     "strings"
@@ -10,39 +11,43 @@ import (
     log "log/slog"
     "bytes"
     "mime"
+    "sort"
+    "time"
 
+    "webservice/backup"
     "webservice/configuration"
+    "webservice/health"
+    "webservice/metrics"
     "webservice/state"
+    "webservice/tracing"
 
     f "github.com/gofiber/fiber/v2"
+    "github.com/gofiber/fiber/v2/middleware/adaptor"
 )
 
 
-func SetRoutes( router *f.App, config *configuration.Config, store state.Store, healthiness *bool ) error {
+func SetRoutes(
+    router *f.App,
+    config *configuration.Config,
+    store state.Store,
+    lifecycle *health.Lifecycle,
+    checker *health.Checker,
+    backups *backup.Scheduler,
+    registry *metrics.Registry,
+    tracer *tracing.Tracer,
+) error {
 
     indexHtmlTemplate, err := template.New( "index" ).Parse( indexHtml )
     if err != nil {
         return err
     }
 
-    metricsTextTemplate, err := template.New( "metrics" ).Parse( metricsText )
-    if err != nil {
-        return err
-    }
-
-    if config.LogLevel == "debug" {
-        router.All( "*", func( c *f.Ctx ) error {
-            log.Debug(
-                fmt.Sprintf( "%s %s  mime:%s  agent:%s",
-                    c.Method(),
-                    c.Path(),
-                    c.Get( f.HeaderContentType, c.Get( f.HeaderAccept, "" ) ),
-                    c.Get( f.HeaderUserAgent ),
-                ),
-            )
-            return c.Next()
-        })
-    }
+    router.Use( requestMetrics( registry ) )
+    router.Use( requestId() )
+    router.Use( requestTracing( tracer ) )
+    router.Use( requestLogging() )
+    router.Use( serverTiming() )
+    store = state.NewObservedStore( store, observeStoreTiming )
 
 
     router.Get( "/", func( c *f.Ctx ) error {
@@ -55,7 +60,7 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
 
         data := indexHtmlData{
             Version: config.Version,
-            Color: config.FontColor,
+            Color: config.GetFontColor(),
         }
 
         buffer := &bytes.Buffer{}
@@ -71,21 +76,32 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
 
     router.Get( "/health", func( c *f.Ctx ) error {
         type response struct {
-            Status  string  `json:"status"  validate:"oneof=pass fail"`
+            Status      string                      `json:"status"  validate:"oneof=pass warn fail"`
+            Version     string                      `json:"version,omitempty"`
+            ReleaseId   string                      `json:"releaseId,omitempty"`
+            ServiceId   string                      `json:"serviceId,omitempty"`
+            Output      string                      `json:"output,omitempty"`
+            Checks      map[ string ] []health.Check  `json:"checks,omitempty"`
         }
 
         c.Set( "Content-Type", "application/health+json; charset=utf-8" )
 
-        var res *response
-        if *healthiness == false {
-            res = &response{
-                Status: "fail",
-            }
+        status, checks := checker.Results()
+        res := &response{
+            Status: status,
+            Version: config.Version,
+            ReleaseId: config.ReleaseId,
+            ServiceId: config.ServiceId,
+            Checks: checks,
+        }
+        if ! lifecycle.IsReady() {
+            res.Status = health.StatusFail
+            res.Output = fmt.Sprintf( "Service is %s", lifecycle.Phase() )
+        }
+
+        if res.Status == health.StatusFail {
             c.Status( http.StatusServiceUnavailable )
         } else {
-            res = &response{
-                Status: "pass",
-            }
             c.Status( http.StatusOK )
         }
 
@@ -97,33 +113,34 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
     })
 
 
+    probe := func( isPassing func() bool ) f.Handler {
+        return func( c *f.Ctx ) error {
+            c.Set( "Content-Type", "text/plain; charset=utf-8" )
+            c.Set( "Cache-Control", "no-store" )
+            if ! isPassing() {
+                c.Status( http.StatusServiceUnavailable )
+            } else {
+                c.Status( http.StatusOK )
+            }
+            return c.SendString( lifecycle.Phase().String() )
+        }
+    }
+
+    router.Get( "/livez", probe( lifecycle.IsAlive ) )
+    router.Get( "/readyz", probe( lifecycle.IsReady ) )
+    router.Get( "/startupz", probe( lifecycle.IsStarted ) )
+
+
+    exposition := adaptor.HTTPHandler( registry.Handler() )
+    otlpExposition := adaptor.HTTPHandler( registry.OTLPHandler( config ) )
     router.Get( "/metrics", func( c *f.Ctx ) error {
         headers := c.GetReqHeaders()
         acceptHeader := strings.Join( headers[ "Accept" ], " " )
-        buffer := &bytes.Buffer{}
 
         if strings.Contains( acceptHeader , "json" ) {
-            // FUTUREWORK: implement https://opentelemetry.io/docs/specs/otlp/#otlphttp
-            return c.SendStatus( http.StatusNotAcceptable )
-        } else {
-            names, err := store.List()
-            if err != nil {
-                log.Debug( err.Error() )
-                return c.SendStatus( http.StatusInternalServerError )
-            }
-
-            data := metricsTextData{
-                Count: len( names ),
-            }
-
-            err = metricsTextTemplate.Execute( buffer, data )
-            if err != nil {
-                return err
-            }
-
-            c.Set( "Content-Type", "text/plain; charset=utf-8" )
-            return c.Send( buffer.Bytes() )
+            return otlpExposition( c )
         }
+        return exposition( c )
     })
 
 
@@ -135,10 +152,53 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
             return nil
         }
 
+        prefix := c.Query( "prefix" )
+        variables := map[ string ] string {}
+        names := []string{}
         for _, envVar := range os.Environ() {
-            _, err := c.WriteString( fmt.Sprintln( envVar ) )
+            name, value, _ := strings.Cut( envVar, "=" )
+            if !strings.HasPrefix( name, prefix ) {
+                continue
+            }
+            variables[ name ] = config.RedactVariable( name, value )
+            names = append( names, name )
+        }
+        sort.Strings( names )
+
+        var settings []configuration.Setting
+        if c.QueryBool( "config" ) {
+            settings = []configuration.Setting{}
+            for _, setting := range config.Settings() {
+                if strings.HasPrefix( setting.Key, prefix ) {
+                    settings = append( settings, setting )
+                }
+            }
+        }
+
+        headers := c.GetReqHeaders()
+        acceptHeader := strings.Join( headers[ "Accept" ], " " )
+        if strings.Contains( acceptHeader , "json" ) {
+            response := map[ string ] any { "environment": variables }
+            if settings != nil {
+                response[ "configuration" ] = settings
+            }
+            return c.JSON( response )
+        }
+
+        lines := []string{}
+        for _, name := range names {
+            lines = append( lines, fmt.Sprintf( "%s=%s", name, variables[ name ] ) )
+        }
+        if settings != nil {
+            lines = append( lines, "", "# Effective configuration" )
+            for _, setting := range settings {
+                lines = append( lines, fmt.Sprintf( "%s=%s", setting.Key, setting.Value ) )
+            }
+        }
+        for _, line := range lines {
+            _, err := c.WriteString( fmt.Sprintln( line ) )
             if err != nil {
-                log.Debug( err.Error() )
+                log.ErrorContext( c.UserContext(), "Response not written", "error", err )
                 c.Status( http.StatusInternalServerError )
                 return err
             }
@@ -154,9 +214,9 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
 
     statePathGroup.Options( "/:name", func( c *f.Ctx ) error {
         name := strings.Clone( c.Params( "name" ) )
-        existingItem, err := store.Fetch( name )
+        existingItem, err := store.Fetch( c.UserContext(), name )
         if err != nil {
-            log.Debug( err.Error() )
+            storeFailed( c, "fetch", name, err )
             return c.SendStatus( http.StatusInternalServerError )
         }
 
@@ -170,11 +230,43 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
 
 
     statePathGroup.Get( "/:name", func( c *f.Ctx ) error {
-        existingItem, err := store.Fetch( c.Params( "name" ) )
-        if err != nil {
-            log.Debug( err.Error() )
-            c.Status( http.StatusInternalServerError )
-            return c.Send( nil )
+        name := strings.Clone( c.Params( "name" ) )
+
+        var existingItem *state.Item
+        var err error
+        if wait := c.Query( "wait" ); len( wait ) >= 1 {
+            timeout, err := time.ParseDuration( wait )
+            if err != nil || timeout < 0 {
+                c.Status( http.StatusBadRequest )
+                return c.SendString(
+                    fmt.Sprintf( "Invalid wait duration: %s", wait ),
+                )
+            }
+            if timeout > configuration.STATE_WAIT_LIMIT {
+                timeout = configuration.STATE_WAIT_LIMIT
+            }
+
+            var changed bool
+            existingItem, changed, err = awaitChange(
+                c.UserContext(), store, name, strings.Clone( c.Query( "after" ) ), timeout,
+            )
+            if err != nil {
+                storeFailed( c, "watch", name, err )
+                return c.SendStatus( http.StatusInternalServerError )
+            }
+            if !changed {
+                if existingItem != nil {
+                    c.Set( "ETag", existingItem.ETag() )
+                }
+                return c.SendStatus( http.StatusNotModified )
+            }
+        } else {
+            existingItem, err = store.Fetch( c.UserContext(), name )
+            if err != nil {
+                storeFailed( c, "fetch", name, err )
+                c.Status( http.StatusInternalServerError )
+                return c.Send( nil )
+            }
         }
 
         if existingItem == nil {
@@ -182,6 +274,7 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
         }
 
         c.Set( "Content-Type", existingItem.MimeType() )
+        c.Set( "ETag", existingItem.ETag() )
         return c.Send( existingItem.Data() )
     })
 
@@ -197,56 +290,59 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
         }
 
         name := strings.Clone( c.Params( "name" ) )
-        existingItem, err := store.Fetch( name )
-        if err != nil {
-            log.Debug( err.Error() )
-            c.Status( http.StatusInternalServerError )
-            return c.Send( nil )
-        }
-
-        if existingItem != nil {
-            if bytes.Equal( existingItem.Data(), c.Body() ) &&
-               existingItem.MimeType() == contentType {
-                c.Set( "Content-Type", "text/plain; charset=utf-8" )
-                c.Status( http.StatusOK )
-                return c.SendString( "Resource not changed" )
-            }
-            c.Status( http.StatusNoContent )
-        } else {
-            c.Status( http.StatusCreated )
-        }
-
         newItem := state.NewItem(
             name,
             contentType,
-            c.Body(),
+            bytes.Clone( c.Body() ),
         )
 
-        if err = store.Add( newItem ); err != nil {
-            log.Debug( err.Error() )
+        existingItem, err := writeEntry( c, store, name, &newItem, func( current *state.Item ) error {
+            if current != nil &&
+               bytes.Equal( current.Data(), newItem.Data() ) &&
+               current.MimeType() == contentType {
+                return errUnchanged
+            }
+            return nil
+        })
+        switch {
+        case errors.Is( err, errPreconditionFailed ):
+            return c.SendStatus( http.StatusPreconditionFailed )
+        case errors.Is( err, errUnchanged ):
+            c.Set( "Content-Type", "text/plain; charset=utf-8" )
+            c.Set( "ETag", existingItem.ETag() )
+            c.Status( http.StatusOK )
+            return c.SendString( "Resource not changed" )
+        case errors.Is( err, state.ErrConflict ):
+            return c.SendStatus( http.StatusConflict )
+        case err != nil:
+            storeFailed( c, "update", name, err )
             c.Status( http.StatusInternalServerError )
             return c.Send( nil )
         }
 
+        if existingItem != nil {
+            c.Status( http.StatusNoContent )
+        } else {
+            c.Status( http.StatusCreated )
+        }
         c.Set( "Content-Location", c.Path() )
+        c.Set( "ETag", newItem.ETag() )
         return c.Send( nil )
     })
 
 
     statePathGroup.Delete( "/:name", func( c *f.Ctx ) error {
         name := strings.Clone( c.Params( "name" ) )
-        existingItem, err := store.Fetch( name )
-        if err != nil {
-            log.Debug( err.Error() )
-            return c.SendStatus( http.StatusInternalServerError )
-        }
-
-        if existingItem == nil {
+        _, err := writeEntry( c, store, name, nil, nil )
+        switch {
+        case errors.Is( err, errNotFound ):
             return c.SendStatus( http.StatusNotFound )
-        }
-
-        if err = store.Remove( name ); err != nil {
-            log.Debug( err.Error() )
+        case errors.Is( err, errPreconditionFailed ):
+            return c.SendStatus( http.StatusPreconditionFailed )
+        case errors.Is( err, state.ErrConflict ):
+            return c.SendStatus( http.StatusConflict )
+        case err != nil:
+            storeFailed( c, "update", name, err )
             return c.SendStatus( http.StatusInternalServerError )
         }
 
@@ -256,9 +352,9 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
 
     statePathGroup.Head( "/:name", func( c *f.Ctx ) error {
         name := strings.Clone( c.Params( "name" ) )
-        existingItem, err := store.Fetch( name )
+        existingItem, err := store.Fetch( c.UserContext(), name )
         if err != nil {
-            log.Debug( err.Error() )
+            storeFailed( c, "fetch", name, err )
             return c.SendStatus( http.StatusInternalServerError )
         }
 
@@ -268,19 +364,18 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
 
         c.Set( "Content-Type", existingItem.MimeType() )
         c.Set( "Content-Length", fmt.Sprintf( "%d", len( existingItem.Data() ) ) )
+        c.Set( "ETag", existingItem.ETag() )
         return c.SendStatus( http.StatusOK )
     })
 
 
-    statePathGroup.Use( "*", func( c *f.Ctx ) error {
-        return c.SendStatus( http.StatusNotFound )
-    })
+    statePathGroup.Use( "*", fallback( http.StatusNotFound ) )
 
 
     router.Get( "/states", func( c *f.Ctx ) error {
-        names, err := store.List()
+        names, err := store.List( c.UserContext() )
         if err != nil {
-            log.Debug( err.Error() )
+            storeFailed( c, "list", "", err )
             return c.SendStatus( http.StatusInternalServerError )
         }
 
@@ -310,9 +405,11 @@ func SetRoutes( router *f.App, config *configuration.Config, store state.Store,
     })
 
 
-    router.Use( func( c *f.Ctx ) error {
-        return c.SendStatus( http.StatusTeapot )
-    })
+    adminRoutes( router.Group( "/admin", adminGuard( config ) ), backups )
+    debugRoutes( router.Group( "/debug", debugGuard( config ), adminGuard( config ) ), config )
+
+
+    router.Use( fallback( http.StatusTeapot ) )
 
 
     return nil
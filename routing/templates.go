@@ -21,14 +21,3 @@ type indexHtmlData struct {
     Version string
     Color   string
 }
-
-
-const metricsText = `
-    # HELP state_entries_quantity The current number of state entries being stored
-    # TYPE state_entries_quantity gauge
-    state_entries_quantity {{ .Count }}
-`
-
-type metricsTextData struct {
-    Count int
-}
@@ -0,0 +1,124 @@
+package routing
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+    log "log/slog"
+
+    "webservice/logging"
+    "webservice/otlp"
+    "webservice/tracing"
+
+    f "github.com/gofiber/fiber/v2"
+)
+
+
+// requestTracing continues the trace of the caller or starts one, the span
+// is named after the route once the request reached its handler and passed
+// back to the caller in the traceresponse header
+func requestTracing( tracer *tracing.Tracer ) f.Handler {
+    return func( c *f.Ctx ) error {
+        method := strings.Clone( c.Method() )
+        parent := tracing.Extract(
+            c.Get( tracing.TraceparentHeader ),
+            strings.Clone( c.Get( tracing.TracestateHeader ) ),
+        )
+        ctx, span := tracer.Trace(
+            c.UserContext(), parent, method, tracing.KindServer,
+            otlp.String( "http.request.method", method ),
+            otlp.String( "url.path", strings.Clone( c.Path() ) ),
+            otlp.String( "client.address", strings.Clone( c.IP() ) ),
+        )
+        if span.Context().IsValid() {
+            ctx = logging.With(
+                ctx,
+                log.String( "traceId", span.Context().TraceId.String() ),
+                log.String( "spanId", span.Context().SpanId.String() ),
+            )
+        }
+        c.SetUserContext( ctx )
+
+        handled( c, c.Next() )
+
+        route := routeOf( c )
+        status := c.Response().StatusCode()
+        span.SetName( method + " " + route )
+        span.SetAttributes(
+            otlp.String( "http.route", route ),
+            otlp.Int( "http.response.status_code", int64( status ) ),
+        )
+        var failure error
+        if status >= http.StatusInternalServerError {
+            failure = errors.New( http.StatusText( status ) )
+        }
+        span.End( failure )
+
+        // unless spans are exported, the span of the caller stands in for
+        // the server's, which is not worth telling the caller
+        if span.Context().IsValid() && span.Context().SpanId != parent.SpanId {
+            c.Set( tracing.TraceresponseHeader, span.Context().Traceparent() )
+        }
+        return nil
+    }
+}
+
+
+type storeTimingKey struct{}
+
+
+// storeTiming adds up the store operations of a request
+type storeTiming struct {
+    mux         sync.Mutex
+    operations  int
+    duration    time.Duration
+}
+
+
+// observeStoreTiming is a state.Observer timing the store operations of
+// the request whose context they are given
+func observeStoreTiming( ctx context.Context, operation string ) ( context.Context, func( error ) ) {
+    timing, ok := ctx.Value( storeTimingKey{} ).( *storeTiming )
+    if !ok {
+        return ctx, func( error ){}
+    }
+    started := time.Now()
+    return ctx, func( error ){
+        timing.mux.Lock()
+        defer timing.mux.Unlock()
+        timing.operations++
+        timing.duration += time.Since( started )
+    }
+}
+
+
+// serverTiming summarizes the store operations of a request in the
+// Server-Timing header, see https://www.w3.org/TR/server-timing/
+func serverTiming() f.Handler {
+    return func( c *f.Ctx ) error {
+        timing := &storeTiming{}
+        c.SetUserContext( context.WithValue( c.UserContext(), storeTimingKey{}, timing ) )
+
+        handled( c, c.Next() )
+
+        timing.mux.Lock()
+        defer timing.mux.Unlock()
+        if timing.operations >= 1 {
+            unit := "operations"
+            if timing.operations == 1 {
+                unit = "operation"
+            }
+            c.Append( "Server-Timing", fmt.Sprintf(
+                `store;dur=%.3f;desc="%d %s"`,
+                float64( timing.duration.Microseconds() ) / 1000,
+                timing.operations,
+                unit,
+            ))
+        }
+        return nil
+    }
+}
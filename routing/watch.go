@@ -0,0 +1,56 @@
+package routing
+
+import (
+    "context"
+    "errors"
+    "strings"
+    "time"
+
+    "webservice/state"
+)
+
+
+func entityTag( item *state.Item ) string {
+    if item == nil {
+        return ""
+    }
+    return item.ETag()
+}
+
+
+func sameEntityTag( a string, b string ) bool {
+    normalize := func( tag string ) string {
+        tag = strings.TrimSpace( tag )
+        tag = strings.TrimPrefix( tag, "W/" )
+        return strings.Trim( tag, "\"" )
+    }
+    return normalize( a ) == normalize( b )
+}
+
+
+func awaitChange( ctx context.Context, store state.Store, name string, after string, timeout time.Duration ) ( *state.Item, bool, error ) {
+    changes, stopWatching := store.Watch( name )
+    defer stopWatching()
+
+    timer := time.NewTimer( timeout )
+    defer timer.Stop()
+
+    for {
+        item, err := store.Fetch( ctx, name )
+        if err != nil {
+            return nil, false, err
+        }
+        if ! sameEntityTag( entityTag( item ), after ) {
+            return item, true, nil
+        }
+
+        select {
+        case _, open := <-changes:
+            if !open {
+                return nil, false, errors.New( "store stopped watching for changes" )
+            }
+        case <-timer.C:
+            return item, false, nil
+        }
+    }
+}
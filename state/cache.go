@@ -0,0 +1,330 @@
+package state
+
+import (
+    "container/list"
+    "context"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "webservice/configuration"
+)
+
+
+type cacheEntry struct {
+    name    string
+    item    *Item
+    stored  time.Time
+}
+
+
+type Cached struct {
+    backend     Store
+
+    maxEntries  int
+    maxBytes    int64
+    ttl         time.Duration
+    serveStale  bool
+
+    entries     map[ string ] *list.Element
+    recency     *list.List
+    bytes       int64
+    generation  uint64
+    mux         sync.Mutex
+
+    hits        atomic.Uint64
+    misses      atomic.Uint64
+    staleHits   atomic.Uint64
+    evictions   atomic.Uint64
+
+    stopObserving   func()
+}
+
+
+func NewCachedStore( backend Store, c *configuration.Config ) *Cached {
+    cache := &Cached{
+        backend: backend,
+        maxEntries: c.CacheMaxEntries,
+        maxBytes: c.CacheMaxBytes,
+        ttl: c.CacheTTL,
+        serveStale: c.CacheServeStale,
+        entries: map[ string ] *list.Element {},
+        recency: list.New(),
+    }
+    if feed, ok := backend.( changeFeed ); ok {
+        cache.stopObserving = feed.observe( cache )
+    }
+    return cache
+}
+
+
+func entrySize( item *Item ) int64 {
+    if item == nil {
+        return 0
+    }
+    return int64( len( item.Data() ) + len( item.MimeType() ) )
+}
+
+
+func ( c *Cached ) lookup( name string ) ( *cacheEntry, uint64, bool ) {
+    c.mux.Lock()
+    defer c.mux.Unlock()
+
+    element, found := c.entries[ name ]
+    if !found {
+        return nil, c.generation, false
+    }
+    entry := element.Value.( *cacheEntry )
+    if time.Since( entry.stored ) > c.ttl {
+        if ! c.serveStale {
+            c.drop( element )
+        }
+        return entry, c.generation, false
+    }
+    c.recency.MoveToFront( element )
+    return entry, c.generation, true
+}
+
+
+func ( c *Cached ) store( name string, item *Item, generation uint64 ) {
+    c.mux.Lock()
+    defer c.mux.Unlock()
+
+    if generation != c.generation {
+        return
+    }
+
+    size := entrySize( item )
+    if size > c.maxBytes {
+        if element, found := c.entries[ name ]; found {
+            c.drop( element )
+        }
+        return
+    }
+
+    if element, found := c.entries[ name ]; found {
+        c.drop( element )
+    }
+    c.entries[ name ] = c.recency.PushFront( &cacheEntry{
+        name: name,
+        item: item,
+        stored: time.Now(),
+    })
+    c.bytes += size
+
+    for len( c.entries ) > c.maxEntries || c.bytes > c.maxBytes {
+        c.drop( c.recency.Back() )
+        c.evictions.Add( 1 )
+    }
+}
+
+
+func ( c *Cached ) drop( element *list.Element ) {
+    entry := element.Value.( *cacheEntry )
+    c.recency.Remove( element )
+    delete( c.entries, entry.name )
+    c.bytes -= entrySize( entry.item )
+}
+
+
+func ( c *Cached ) Invalidate( name string ) {
+    c.mux.Lock()
+    defer c.mux.Unlock()
+
+    c.generation++
+    if element, found := c.entries[ name ]; found {
+        c.drop( element )
+    }
+}
+
+
+func ( c *Cached ) changed( name string ) {
+    c.Invalidate( name )
+}
+
+
+func ( c *Cached ) resynchronize() {
+    c.mux.Lock()
+    defer c.mux.Unlock()
+
+    c.generation++
+    c.entries = map[ string ] *list.Element {}
+    c.recency.Init()
+    c.bytes = 0
+}
+
+
+func ( c *Cached ) observe( observer changeObserver ) func() {
+    if feed, ok := c.backend.( changeFeed ); ok {
+        return feed.observe( observer )
+    }
+    return func(){}
+}
+
+
+func ( c *Cached ) Add( ctx context.Context, i Item ) error {
+    defer c.Invalidate( i.Name() )
+    return c.backend.Add( ctx, i )
+}
+
+
+func ( c *Cached ) Remove( ctx context.Context, name string ) error {
+    defer c.Invalidate( name )
+    return c.backend.Remove( ctx, name )
+}
+
+
+func ( c *Cached ) Update( ctx context.Context, name string, item *Item, condition Condition ) error {
+    defer c.Invalidate( name )
+    return c.backend.Update( ctx, name, item, condition )
+}
+
+
+func ( c *Cached ) Fetch( ctx context.Context, name string ) ( *Item, error ) {
+    entry, generation, fresh := c.lookup( name )
+    if fresh {
+        c.hits.Add( 1 )
+        return entry.item, nil
+    }
+    c.misses.Add( 1 )
+
+    item, err := c.backend.Fetch( ctx, name )
+    if err != nil {
+        if entry != nil && c.serveStale {
+            c.staleHits.Add( 1 )
+            return entry.item, nil
+        }
+        return nil, err
+    }
+
+    c.store( name, item, generation )
+    return item, nil
+}
+
+
+func ( c *Cached ) List( ctx context.Context ) ( []string, error ) {
+    return c.backend.List( ctx )
+}
+
+
+func ( c *Cached ) Snapshot( ctx context.Context ) ( []Item, error ) {
+    return Snapshot( ctx, c.backend )
+}
+
+
+func ( c *Cached ) Stats( ctx context.Context ) ( Stats, error ) {
+    if counted, ok := c.backend.( Counted ); ok {
+        return counted.Stats( ctx )
+    }
+    return Stats{}, ErrNotCounted
+}
+
+
+func ( c *Cached ) Watch( name string ) ( <-chan struct{}, func() ) {
+    backendChanges, stopBackend := c.backend.Watch( name )
+    changes := make( chan struct{}, 1 )
+    done := make( chan struct{} )
+
+    go func(){
+        defer close( changes )
+        for {
+            select {
+            case _, open := <-backendChanges:
+                if !open {
+                    return
+                }
+                c.Invalidate( name )
+                select {
+                case changes <- struct{}{}:
+                default:
+                }
+            case <-done:
+                return
+            }
+        }
+    }()
+
+    stopping := sync.Once{}
+    stop := func(){
+        stopping.Do( func(){
+            close( done )
+            stopBackend()
+        })
+    }
+    return changes, stop
+}
+
+
+func ( c *Cached ) Ping( ctx context.Context ) error {
+    return c.backend.Ping( ctx )
+}
+
+
+func ( c *Cached ) Verify( ctx context.Context ) error {
+    if v, ok := c.backend.( verifier ); ok {
+        return v.Verify( ctx )
+    }
+    return c.backend.Ping( ctx )
+}
+
+
+func ( c *Cached ) Disconnect() error {
+    if c.stopObserving != nil {
+        c.stopObserving()
+    }
+    c.resynchronize()
+
+    return c.backend.Disconnect()
+}
+
+
+func ( c *Cached ) Metrics() []Metric {
+    c.mux.Lock()
+    entries := len( c.entries )
+    bytes := c.bytes
+    c.mux.Unlock()
+
+    metrics := []Metric{
+        {
+            Name: "state_cache_hits_total",
+            Help: "The number of state entries served from the cache",
+            Type: "counter",
+            Value: float64( c.hits.Load() ),
+        },
+        {
+            Name: "state_cache_misses_total",
+            Help: "The number of state entries fetched from the backend",
+            Type: "counter",
+            Value: float64( c.misses.Load() ),
+        },
+        {
+            Name: "state_cache_stale_hits_total",
+            Help: "The number of expired state entries served while the backend failed",
+            Type: "counter",
+            Value: float64( c.staleHits.Load() ),
+        },
+        {
+            Name: "state_cache_evictions_total",
+            Help: "The number of state entries evicted from the cache due to size limits",
+            Type: "counter",
+            Value: float64( c.evictions.Load() ),
+        },
+        {
+            Name: "state_cache_entries",
+            Help: "The current number of state entries being cached",
+            Type: "gauge",
+            Value: float64( entries ),
+        },
+        {
+            Name: "state_cache_bytes",
+            Help: "The current size of all cached state entries, in bytes",
+            Type: "gauge",
+            Value: float64( bytes ),
+        },
+    }
+
+    if backend, ok := c.backend.( Instrumented ); ok {
+        metrics = append( metrics, backend.Metrics()... )
+    }
+    return metrics
+}
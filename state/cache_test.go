@@ -0,0 +1,149 @@
+package state
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "webservice/configuration"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+type failingStore struct {
+    *Ephemeral
+    failing bool
+}
+
+func ( s *failingStore ) Fetch( ctx context.Context, name string ) ( *Item, error ) {
+    if s.failing {
+        return nil, errors.New( "backend not reachable" )
+    }
+    return s.Ephemeral.Fetch( ctx, name )
+}
+
+
+// misconfiguredStore is reachable, but not the store it is expected to be
+type misconfiguredStore struct {
+    *Ephemeral
+}
+
+func ( s misconfiguredStore ) Verify( ctx context.Context ) error {
+    return errors.New( "Database 1 expected to be selected, got 0" )
+}
+
+
+func newTestCache( backend Store, ttl time.Duration, serveStale bool ) *Cached {
+    return NewCachedStore( backend, &configuration.Config{
+        CacheMaxEntries: 2,
+        CacheMaxBytes: 64,
+        CacheTTL: ttl,
+        CacheServeStale: serveStale,
+    })
+}
+
+
+func metricValue( metrics []Metric, name string ) float64 {
+    for _, metric := range metrics {
+        if metric.Name == name {
+            return metric.Value
+        }
+    }
+    return -1
+}
+
+
+func TestCachedFetch( t *testing.T ){
+    backend := NewEphemeralStore()
+    cache := newTestCache( backend, time.Minute, false )
+
+    item := testItems[ 0 ]
+    assert.Nil( t, cache.Add( context.Background(), item ) )
+
+    for i := 0; i < 3; i++ {
+        fetched, err := cache.Fetch( context.Background(), item.Name() )
+        assert.Nil( t, err )
+        assert.Equal( t, item.Data(), fetched.Data() )
+    }
+    assert.Equal( t, float64( 1 ), metricValue( cache.Metrics(), "state_cache_misses_total" ) )
+    assert.Equal( t, float64( 2 ), metricValue( cache.Metrics(), "state_cache_hits_total" ) )
+
+    changed := NewItem( item.Name(), item.MimeType(), []byte( "changed" ) )
+    assert.Nil( t, cache.Add( context.Background(), changed ) )
+    fetched, err := cache.Fetch( context.Background(), item.Name() )
+    assert.Nil( t, err )
+    assert.Equal( t, []byte( "changed" ), fetched.Data() )
+
+    updated := NewItem( item.Name(), item.MimeType(), []byte( "updated" ) )
+    assert.Nil( t, cache.Update( context.Background(), item.Name(), &updated, func( current *Item ) error {
+        assert.Equal( t, []byte( "changed" ), current.Data() )
+        return nil
+    }))
+    fetched, err = cache.Fetch( context.Background(), item.Name() )
+    assert.Nil( t, err )
+    assert.Equal( t, []byte( "updated" ), fetched.Data() )
+
+    assert.Nil( t, cache.Remove( context.Background(), item.Name() ) )
+    fetched, err = cache.Fetch( context.Background(), item.Name() )
+    assert.Nil( t, err )
+    assert.Nil( t, fetched )
+}
+
+
+func TestCachedLimits( t *testing.T ){
+    backend := NewEphemeralStore()
+    cache := newTestCache( backend, time.Minute, false )
+
+    for _, name := range []string{ "a", "b", "c" } {
+        assert.Nil( t, backend.Add( context.Background(), NewItem( name, "text/plain", []byte( name ) ) ) )
+        _, err := cache.Fetch( context.Background(), name )
+        assert.Nil( t, err )
+    }
+    assert.Equal( t, float64( 2 ), metricValue( cache.Metrics(), "state_cache_entries" ) )
+    assert.Equal( t, float64( 1 ), metricValue( cache.Metrics(), "state_cache_evictions_total" ) )
+
+    large := NewItem( "large", "text/plain", make( []byte, 128 ) )
+    assert.Nil( t, cache.Add( context.Background(), large ) )
+    _, err := cache.Fetch( context.Background(), large.Name() )
+    assert.Nil( t, err )
+    assert.Equal( t, float64( 2 ), metricValue( cache.Metrics(), "state_cache_entries" ) )
+    assert.LessOrEqual( t, metricValue( cache.Metrics(), "state_cache_bytes" ), float64( 64 ) )
+}
+
+
+func TestCachedStale( t *testing.T ){
+    backend := &failingStore{ Ephemeral: NewEphemeralStore() }
+    item := testItems[ 0 ]
+    assert.Nil( t, backend.Add( context.Background(), item ) )
+
+    strict := newTestCache( backend, time.Millisecond, false )
+    lenient := newTestCache( backend, time.Millisecond, true )
+    for _, cache := range []*Cached{ strict, lenient } {
+        _, err := cache.Fetch( context.Background(), item.Name() )
+        assert.Nil( t, err )
+    }
+
+    time.Sleep( time.Millisecond * 5 )
+    backend.failing = true
+
+    _, err := strict.Fetch( context.Background(), item.Name() )
+    assert.NotNil( t, err )
+
+    fetched, err := lenient.Fetch( context.Background(), item.Name() )
+    assert.Nil( t, err )
+    assert.Equal( t, item.Data(), fetched.Data() )
+    assert.Equal( t, float64( 1 ), metricValue( lenient.Metrics(), "state_cache_stale_hits_total" ) )
+}
+
+
+func TestCachedVerify( t *testing.T ){
+    cache := newTestCache( misconfiguredStore{ NewEphemeralStore() }, time.Minute, false )
+    ctx, cancel := context.WithTimeout( context.Background(), time.Millisecond * 50 )
+    defer cancel()
+    _, err := Await( ctx, cache, time.Millisecond * 10 )
+    assert.ErrorContains( t, err, "Database 1 expected to be selected" )
+
+    assert.Nil( t, newTestCache( NewEphemeralStore(), time.Minute, false ).Verify( context.Background() ) )
+}
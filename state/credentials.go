@@ -0,0 +1,50 @@
+package state
+
+import (
+    "os"
+    "sync/atomic"
+)
+
+
+// rotatingSecret holds the content of a secret file, which may be read
+// again to rotate the secret without a restart
+type rotatingSecret struct {
+    path    string
+    value   atomic.Pointer[ string ]
+}
+
+
+func newRotatingSecret( path string ) ( *rotatingSecret, error ) {
+    s := &rotatingSecret{ path: path }
+    value, err := s.read()
+    if err != nil {
+        return nil, err
+    }
+    s.set( value )
+    return s, nil
+}
+
+
+func ( s *rotatingSecret ) read() ( string, error ) {
+    if len( s.path ) <= 0 {
+        return s.get(), nil
+    }
+    content, err := os.ReadFile( s.path )
+    if err != nil {
+        return "", err
+    }
+    return string( content ), nil
+}
+
+
+func ( s *rotatingSecret ) get() string {
+    if value := s.value.Load(); value != nil {
+        return *value
+    }
+    return ""
+}
+
+
+func ( s *rotatingSecret ) set( value string ) {
+    s.value.Store( &value )
+}
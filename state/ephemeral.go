@@ -1,6 +1,7 @@
 package state
 
 import (
+    "context"
     "errors"
     "sync"
 )
@@ -8,54 +9,72 @@ import (
 
 type Ephemeral struct {
     store map[ string ] Item
+    stats Stats
     mux sync.Mutex
+    changes notifier
 }
 
 
 func NewEphemeralStore() *Ephemeral {
     return &Ephemeral{
         store: map[ string ] Item {},
+        stats: Stats{ MimeTypes: map[ string ] int64 {} },
         mux: sync.Mutex{},
     }
 }
 
 
-func ( e *Ephemeral ) Add( i Item ) error {
-    if e.store == nil {
-        return errors.New( "ephemeral storage not available" )
-    }
-
-    name := i.Name()
+func ( e *Ephemeral ) Add( ctx context.Context, i Item ) error {
+    return e.Update( ctx, i.Name(), &i, nil )
+}
 
-    e.mux.Lock()
-    e.store[ name ] = i
-    e.mux.Unlock()
 
-    return nil
+func ( e *Ephemeral ) Remove( ctx context.Context, name string ) error {
+    return e.Update( ctx, name, nil, nil )
 }
 
 
-func ( e *Ephemeral ) Remove( name string ) error {
+func ( e *Ephemeral ) Update( ctx context.Context, name string, item *Item, condition Condition ) error {
+    e.mux.Lock()
     if e.store == nil {
+        e.mux.Unlock()
         return errors.New( "ephemeral storage not available" )
     }
-
-    e.mux.Lock()
-    delete( e.store, name )
+    previous, found := e.store[ name ]
+    if condition != nil {
+        var current *Item
+        if found {
+            current = &previous
+        }
+        if err := condition( current ); err != nil {
+            e.mux.Unlock()
+            return err
+        }
+    }
+    if found {
+        e.stats.add( &previous, -1 )
+        delete( e.store, name )
+    }
+    if item != nil {
+        e.store[ name ] = *item
+        e.stats.add( item, 1 )
+    }
     e.mux.Unlock()
 
+    e.changes.notify( name )
+
     return nil
 }
 
 
-func ( e *Ephemeral ) Fetch( name string ) ( *Item, error ) {
+func ( e *Ephemeral ) Fetch( ctx context.Context, name string ) ( *Item, error ) {
+    e.mux.Lock()
+    defer e.mux.Unlock()
     if e.store == nil {
         return nil, errors.New( "ephemeral storage not available" )
     }
 
-    e.mux.Lock()
     item, found := e.store[ name ]
-    e.mux.Unlock()
 
     if !found {
         return nil, nil
@@ -64,23 +83,72 @@ func ( e *Ephemeral ) Fetch( name string ) ( *Item, error ) {
 }
 
 
-func ( e *Ephemeral ) List() ( []string, error ) {
+func ( e *Ephemeral ) List( ctx context.Context ) ( []string, error ) {
+    e.mux.Lock()
+    defer e.mux.Unlock()
     if e.store == nil {
         return nil, errors.New( "ephemeral storage not available" )
     }
 
-    e.mux.Lock()
     names := make( []string, 0, len( e.store ) )
     for _, item := range e.store {
         names = append( names, item.Name() )
     }
-    e.mux.Unlock()
 
     return names, nil
 }
 
 
+func ( e *Ephemeral ) Snapshot( ctx context.Context ) ( []Item, error ) {
+    e.mux.Lock()
+    defer e.mux.Unlock()
+    if e.store == nil {
+        return nil, errors.New( "ephemeral storage not available" )
+    }
+
+    items := make( []Item, 0, len( e.store ) )
+    for _, item := range e.store {
+        items = append( items, item )
+    }
+
+    return items, nil
+}
+
+
+func ( e *Ephemeral ) Stats( ctx context.Context ) ( Stats, error ) {
+    e.mux.Lock()
+    defer e.mux.Unlock()
+    if e.store == nil {
+        return Stats{}, errors.New( "ephemeral storage not available" )
+    }
+    return e.stats.clone(), nil
+}
+
+
+func ( e *Ephemeral ) Watch( name string ) ( <-chan struct{}, func() ) {
+    return e.changes.watch( name )
+}
+
+
+func ( e *Ephemeral ) observe( observer changeObserver ) func() {
+    return e.changes.observe( observer )
+}
+
+
+func ( e *Ephemeral ) Ping( ctx context.Context ) error {
+    e.mux.Lock()
+    defer e.mux.Unlock()
+    if e.store == nil {
+        return errors.New( "ephemeral storage not available" )
+    }
+    return nil
+}
+
+
 func ( e *Ephemeral ) Disconnect() error {
+    e.mux.Lock()
     e.store = nil
+    e.mux.Unlock()
+    e.changes.closeAll()
     return nil
 }
@@ -1,9 +1,13 @@
 package state
 
 import (
+    "context"
+    "errors"
+    "fmt"
     "testing"
     "mime"
     "sync"
+    "sync/atomic"
 
     "github.com/stretchr/testify/assert"
 )
@@ -28,10 +32,130 @@ func TestEphemeralAdd( t *testing.T ){
         wg.Add( 1 )
         go func( i Item ){
             defer wg.Done()
-            es.Add( i )
+            es.Add( context.Background(), i )
         }( item )
     }
     wg.Wait()
 
     assert.Len( t, es.store, len( testItems ) )
 }
+
+
+// createConcurrently creates an entry from several goroutines at once, each
+// only if there is none yet, and returns how many succeeded
+func createConcurrently( store Store, name string ) int {
+    created := atomic.Int32{}
+    wg := &sync.WaitGroup{}
+    for i := 0; i < 10; i++ {
+        wg.Add( 1 )
+        go func( i int ){
+            defer wg.Done()
+            item := NewItem( name, "text/plain", []byte( fmt.Sprint( i ) ) )
+            err := store.Update( context.Background(), name, &item, func( current *Item ) error {
+                if current != nil {
+                    return errors.New( "entry exists" )
+                }
+                return nil
+            })
+            if err == nil {
+                created.Add( 1 )
+            }
+        }( i )
+    }
+    wg.Wait()
+    return int( created.Load() )
+}
+
+
+func TestEphemeralUpdate( t *testing.T ){
+    es := NewEphemeralStore()
+    assert.Equal( t, 1, createConcurrently( es, "created" ) )
+
+    rejected := errors.New( "rejected" )
+    item := testItems[ 0 ]
+    err := es.Update( context.Background(), item.Name(), &item, func( current *Item ) error {
+        assert.Nil( t, current )
+        return rejected
+    })
+    assert.Equal( t, rejected, err )
+    assert.Len( t, es.store, 1 )
+
+    assert.Nil( t, es.Update( context.Background(), item.Name(), &item, nil ) )
+    assert.Nil( t, es.Update( context.Background(), item.Name(), nil, func( current *Item ) error {
+        assert.Equal( t, item.Data(), current.Data() )
+        return nil
+    }))
+    stats, err := es.Stats( context.Background() )
+    assert.Nil( t, err )
+    assert.Equal( t, int64( 1 ), stats.Entries )
+}
+
+
+func TestEphemeralWatch( t *testing.T ){
+    es := NewEphemeralStore()
+    item := testItems[ 0 ]
+
+    changes, stop := es.Watch( item.Name() )
+    es.Add( context.Background(), testItems[ 1 ] )
+    select {
+    case <-changes:
+        t.Fatal( "notified about an unrelated entry" )
+    default:
+    }
+
+    es.Add( context.Background(), item )
+    _, open := <-changes
+    assert.True( t, open )
+
+    stop()
+    _, open = <-changes
+    assert.False( t, open )
+
+    changes, _ = es.Watch( item.Name() )
+    es.Disconnect()
+    _, open = <-changes
+    assert.False( t, open )
+}
+
+
+func TestEphemeralStats( t *testing.T ){
+    es := NewEphemeralStore()
+    ctx := context.Background()
+    for _, item := range testItems {
+        assert.Nil( t, es.Add( ctx, item ) )
+    }
+    assert.Nil( t, es.Add( ctx, NewItem( "foo", "Text/HTML", []byte( "replaced" ) ) ) )
+    assert.Nil( t, es.Remove( ctx, "Som!_🎵nam3" ) )
+    assert.Nil( t, es.Remove( ctx, "missing" ) )
+
+    stats, err := Count( ctx, es )
+    assert.Nil( t, err )
+    assert.Equal( t, Stats{
+        Entries: 2,
+        Bytes: 8,
+        MimeTypes: map[ string ] int64 { "text/html": 2 },
+    }, stats )
+}
+
+
+func TestEphemeralDisconnect( t *testing.T ){
+    es := NewEphemeralStore()
+
+    wg := &sync.WaitGroup{}
+    for _, item := range testItems {
+        wg.Add( 1 )
+        go func( i Item ){
+            defer wg.Done()
+            _ = es.Ping( context.Background() )
+            _ = es.Add( context.Background(), i )
+            _, _ = es.Fetch( context.Background(), i.Name() )
+        }( item )
+    }
+    assert.Nil( t, es.Disconnect() )
+    wg.Wait()
+
+    assert.NotNil( t, es.Ping( context.Background() ) )
+    assert.NotNil( t, es.Add( context.Background(), testItems[ 0 ] ) )
+    _, err := es.List( context.Background() )
+    assert.NotNil( t, err )
+}
@@ -0,0 +1,114 @@
+package state
+
+import (
+    "context"
+    "strings"
+    "time"
+    log "log/slog"
+
+    "webservice/otlp"
+    "webservice/tracing"
+
+    db "github.com/redis/go-redis/v9"
+)
+
+
+// commandLogging logs database commands with the attributes of the request
+// they were issued for, as commands themselves carry no request id
+type commandLogging struct{}
+
+
+func ( commandLogging ) DialHook( next db.DialHook ) db.DialHook {
+    return next
+}
+
+
+func ( commandLogging ) ProcessHook( next db.ProcessHook ) db.ProcessHook {
+    return func( ctx context.Context, cmd db.Cmder ) error {
+        if ! log.Default().Enabled( ctx, log.LevelDebug ) {
+            return next( ctx, cmd )
+        }
+        started := time.Now()
+        err := next( ctx, cmd )
+        logCommand( ctx, cmd.Name(), started, err )
+        return err
+    }
+}
+
+
+func ( commandLogging ) ProcessPipelineHook( next db.ProcessPipelineHook ) db.ProcessPipelineHook {
+    return func( ctx context.Context, cmds []db.Cmder ) error {
+        if ! log.Default().Enabled( ctx, log.LevelDebug ) {
+            return next( ctx, cmds )
+        }
+        started := time.Now()
+        err := next( ctx, cmds )
+        names := make( []string, 0, len( cmds ) )
+        for _, cmd := range cmds {
+            names = append( names, cmd.Name() )
+        }
+        logCommand( ctx, strings.Join( names, " " ), started, err )
+        return err
+    }
+}
+
+
+func logCommand( ctx context.Context, command string, started time.Time, err error ) {
+    attrs := []any{ "command", command, "duration", time.Since( started ) }
+    if err := commandError( err ); err != nil {
+        attrs = append( attrs, "error", err )
+    }
+    log.DebugContext( ctx, "Database command processed", attrs... )
+}
+
+
+// commandTracing times database commands as part of the trace of the
+// request they were issued for
+type commandTracing struct{}
+
+
+func ( commandTracing ) DialHook( next db.DialHook ) db.DialHook {
+    return next
+}
+
+
+func ( commandTracing ) ProcessHook( next db.ProcessHook ) db.ProcessHook {
+    return func( ctx context.Context, cmd db.Cmder ) error {
+        ctx, span := tracing.Start(
+            ctx, cmd.Name(), tracing.KindClient,
+            otlp.String( "db.system", "redis" ),
+            otlp.String( "db.operation", cmd.Name() ),
+        )
+        err := next( ctx, cmd )
+        span.End( commandError( err ) )
+        return err
+    }
+}
+
+
+func ( commandTracing ) ProcessPipelineHook( next db.ProcessPipelineHook ) db.ProcessPipelineHook {
+    return func( ctx context.Context, cmds []db.Cmder ) error {
+        names := make( []string, 0, len( cmds ) )
+        for _, cmd := range cmds {
+            names = append( names, cmd.Name() )
+        }
+        ctx, span := tracing.Start(
+            ctx, "pipeline", tracing.KindClient,
+            otlp.String( "db.system", "redis" ),
+            otlp.String( "db.operation", strings.Join( names, " " ) ),
+            otlp.Int( "db.redis.commands", int64( len( cmds ) ) ),
+        )
+        err := next( ctx, cmds )
+        span.End( commandError( err ) )
+        return err
+    }
+}
+
+
+// commandError ignores missing keys, which are not a failure of the command
+func commandError( err error ) error {
+    if err == db.Nil {
+        return nil
+    }
+    return err
+}
@@ -1,5 +1,11 @@
 package state
 
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+)
+
 
 type Item struct {
     name        string
@@ -28,3 +34,17 @@ func ( i *Item ) MimeType() string {
 func ( i *Item ) Data() []byte {
     return i.data
 }
+
+func ( i *Item ) ETag() string {
+    hash := sha256.New()
+    hash.Write( []byte( i.mimeType ) )
+    hash.Write( []byte{ 0 } )
+    hash.Write( i.data )
+    return fmt.Sprintf( "\"%x\"", hash.Sum( nil )[:16] )
+}
+
+
+func ( i *Item ) Checksum() string {
+    sum := sha256.Sum256( i.data )
+    return hex.EncodeToString( sum[:] )
+}
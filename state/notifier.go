@@ -0,0 +1,121 @@
+package state
+
+import (
+    "sync"
+)
+
+
+type changeObserver interface {
+    changed( name string )
+    resynchronize()
+}
+
+
+type changeFeed interface {
+    observe( observer changeObserver ) func()
+}
+
+
+type notifier struct {
+    watchers map[ string ] map[ chan struct{} ] struct{}
+    observers map[ *changeObserver ] struct{}
+    mux sync.Mutex
+}
+
+
+func ( n *notifier ) watch( name string ) ( <-chan struct{}, func() ) {
+    changes := make( chan struct{}, 1 )
+
+    n.mux.Lock()
+    if n.watchers == nil {
+        n.watchers = map[ string ] map[ chan struct{} ] struct{} {}
+    }
+    if _, found := n.watchers[ name ]; !found {
+        n.watchers[ name ] = map[ chan struct{} ] struct{} {}
+    }
+    n.watchers[ name ][ changes ] = struct{}{}
+    n.mux.Unlock()
+
+    stop := func(){
+        n.mux.Lock()
+        defer n.mux.Unlock()
+
+        if _, found := n.watchers[ name ][ changes ]; !found {
+            return
+        }
+        delete( n.watchers[ name ], changes )
+        if len( n.watchers[ name ] ) <= 0 {
+            delete( n.watchers, name )
+        }
+        close( changes )
+    }
+
+    return changes, stop
+}
+
+
+func ( n *notifier ) observe( observer changeObserver ) func() {
+    key := &observer
+
+    n.mux.Lock()
+    if n.observers == nil {
+        n.observers = map[ *changeObserver ] struct{} {}
+    }
+    n.observers[ key ] = struct{}{}
+    n.mux.Unlock()
+
+    return func(){
+        n.mux.Lock()
+        delete( n.observers, key )
+        n.mux.Unlock()
+    }
+}
+
+
+func ( n *notifier ) notify( name string ) {
+    n.mux.Lock()
+    defer n.mux.Unlock()
+
+    for observer := range n.observers {
+        ( *observer ).changed( name )
+    }
+    for changes := range n.watchers[ name ] {
+        signal( changes )
+    }
+}
+
+
+func ( n *notifier ) notifyAll() {
+    n.mux.Lock()
+    defer n.mux.Unlock()
+
+    for observer := range n.observers {
+        ( *observer ).resynchronize()
+    }
+    for _, watchers := range n.watchers {
+        for changes := range watchers {
+            signal( changes )
+        }
+    }
+}
+
+
+func signal( changes chan struct{} ) {
+    select {
+    case changes <- struct{}{}:
+    default:
+    }
+}
+
+
+func ( n *notifier ) closeAll() {
+    n.mux.Lock()
+    defer n.mux.Unlock()
+
+    for _, watchers := range n.watchers {
+        for changes := range watchers {
+            close( changes )
+        }
+    }
+    n.watchers = nil
+}
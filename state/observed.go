@@ -0,0 +1,149 @@
+package state
+
+import (
+    "context"
+)
+
+
+// Observer is called as a store operation starts, the context it returns is
+// passed on to the backend and the function it returns is called with the
+// outcome of the operation
+type Observer func( ctx context.Context, operation string ) ( context.Context, func( err error ) )
+
+
+type Observed struct {
+    backend     Store
+    observers   []Observer
+}
+
+
+func NewObservedStore( backend Store, observers ...Observer ) *Observed {
+    return &Observed{
+        backend: backend,
+        observers: observers,
+    }
+}
+
+
+func ( o *Observed ) start( ctx context.Context, operation string ) ( context.Context, func( error ) ) {
+    finishers := make( []func( error ), 0, len( o.observers ) )
+    for _, observer := range o.observers {
+        var finish func( error )
+        ctx, finish = observer( ctx, operation )
+        finishers = append( finishers, finish )
+    }
+    return ctx, func( err error ){
+        for i := len( finishers ) - 1; i >= 0; i-- {
+            finishers[ i ]( err )
+        }
+    }
+}
+
+
+func ( o *Observed ) Add( ctx context.Context, i Item ) error {
+    ctx, finish := o.start( ctx, "add" )
+    err := o.backend.Add( ctx, i )
+    finish( err )
+    return err
+}
+
+
+func ( o *Observed ) Remove( ctx context.Context, name string ) error {
+    ctx, finish := o.start( ctx, "remove" )
+    err := o.backend.Remove( ctx, name )
+    finish( err )
+    return err
+}
+
+
+// Update reports a write rejected by condition as successful, as the store
+// did not fail
+func ( o *Observed ) Update( ctx context.Context, name string, item *Item, condition Condition ) error {
+    ctx, finish := o.start( ctx, "update" )
+    var rejected error
+    observed := condition
+    if condition != nil {
+        observed = func( current *Item ) error {
+            rejected = condition( current )
+            return rejected
+        }
+    }
+    err := o.backend.Update( ctx, name, item, observed )
+    if err != nil && err == rejected {
+        finish( nil )
+    } else {
+        finish( err )
+    }
+    return err
+}
+
+
+func ( o *Observed ) Fetch( ctx context.Context, name string ) ( *Item, error ) {
+    ctx, finish := o.start( ctx, "fetch" )
+    item, err := o.backend.Fetch( ctx, name )
+    finish( err )
+    return item, err
+}
+
+
+func ( o *Observed ) List( ctx context.Context ) ( []string, error ) {
+    ctx, finish := o.start( ctx, "list" )
+    names, err := o.backend.List( ctx )
+    finish( err )
+    return names, err
+}
+
+
+func ( o *Observed ) Snapshot( ctx context.Context ) ( []Item, error ) {
+    ctx, finish := o.start( ctx, "snapshot" )
+    items, err := Snapshot( ctx, o.backend )
+    finish( err )
+    return items, err
+}
+
+
+func ( o *Observed ) Stats( ctx context.Context ) ( Stats, error ) {
+    if counted, ok := o.backend.( Counted ); ok {
+        return counted.Stats( ctx )
+    }
+    return Stats{}, ErrNotCounted
+}
+
+
+func ( o *Observed ) Watch( name string ) ( <-chan struct{}, func() ) {
+    return o.backend.Watch( name )
+}
+
+
+func ( o *Observed ) observe( observer changeObserver ) func() {
+    if feed, ok := o.backend.( changeFeed ); ok {
+        return feed.observe( observer )
+    }
+    return func(){}
+}
+
+
+func ( o *Observed ) Ping( ctx context.Context ) error {
+    return o.backend.Ping( ctx )
+}
+
+
+func ( o *Observed ) Verify( ctx context.Context ) error {
+    if v, ok := o.backend.( verifier ); ok {
+        return v.Verify( ctx )
+    }
+    return o.backend.Ping( ctx )
+}
+
+
+func ( o *Observed ) Disconnect() error {
+    return o.backend.Disconnect()
+}
+
+
+func ( o *Observed ) Metrics() []Metric {
+    if backend, ok := o.backend.( Instrumented ); ok {
+        return backend.Metrics()
+    }
+    return nil
+}
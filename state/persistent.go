@@ -1,87 +1,526 @@
 package state
 
 import (
+    "errors"
     "fmt"
+    "strconv"
+    "strings"
     "runtime"
     "context"
     "time"
     "os"
+    "sync"
+    "sync/atomic"
     log "log/slog"
 
     "webservice/configuration"
+    "webservice/logging"
 
     db "github.com/redis/go-redis/v9"
 )
 
 
 
+const changesChannel = "webservice:changes"
+
+// statsKey holds the counters of all entries, an entry is not able to be
+// named alike, as names never contain a slash
+const statsKey = "webservice/stats"
+
+// countingKey marks that entries are being counted, it collects the names
+// written meanwhile besides the member /, which keeps the set from being empty
+const countingKey = "webservice/counting"
+
+
+// countFunction is shared by the scripts, count updates the counters in a
+// hash by an entry
+const countFunction = `
+    local function mediaType( mimeType )
+        local t = string.match( mimeType, '^[^;]*' )
+        return string.lower( string.match( t, '^%s*(.-)%s*$' ) )
+    end
+
+    local function count( key, mimeType, size, sign )
+        redis.call( 'HINCRBY', key, 'entries', sign )
+        redis.call( 'HINCRBY', key, 'bytes', sign * size )
+        local field = 'mime:' .. mediaType( mimeType )
+        if redis.call( 'HINCRBY', key, field, sign ) <= 0 then
+            redis.call( 'HDEL', key, field )
+        end
+    end
+`
+
+
+// countingScript writes or removes an entry (KEYS[1]) and updates the
+// counters (KEYS[2]) along, while they are being counted (KEYS[3]) the
+// name is recorded instead
+var countingScript = db.NewScript( countFunction + `
+    local counted = redis.call( 'EXISTS', KEYS[2] ) == 1
+    if not counted and redis.call( 'EXISTS', KEYS[3] ) == 1 then
+        redis.call( 'SADD', KEYS[3], KEYS[1] )
+    end
+
+    local previous = redis.call( 'HGET', KEYS[1], 'mime' )
+    if counted and previous then
+        count( KEYS[2], previous, redis.call( 'HSTRLEN', KEYS[1], 'data' ), -1 )
+    end
+
+    if ARGV[1] == 'add' then
+        redis.call( 'HSET', KEYS[1], 'mime', ARGV[2], 'data', ARGV[3] )
+        if counted then
+            count( KEYS[2], ARGV[2], string.len( ARGV[3] ), 1 )
+        end
+    else
+        redis.call( 'DEL', KEYS[1] )
+    end
+    return 0
+`)
+
+
+// initializingScript sets the counters (KEYS[1]) to the scanned entries
+// (ARGV[2:]) and adds the entries written while counting (KEYS[2]), unless
+// more were written than the scan excluded (ARGV[1]); it returns 0 to be
+// retried and -1 if counting has to start over
+var initializingScript = db.NewScript( countFunction + `
+    if redis.call( 'EXISTS', KEYS[1] ) == 1 then
+        redis.call( 'DEL', KEYS[2] )
+        return 1
+    end
+    if redis.call( 'EXISTS', KEYS[2] ) == 0 then
+        return -1
+    end
+    if redis.call( 'SCARD', KEYS[2] ) ~= tonumber( ARGV[1] ) + 1 then
+        return 0
+    end
+
+    redis.call( 'HSET', KEYS[1], unpack( ARGV, 2 ) )
+    for _, name in ipairs( redis.call( 'SMEMBERS', KEYS[2] ) ) do
+        local mimeType = redis.call( 'HGET', name, 'mime' )
+        if mimeType then
+            count( KEYS[1], mimeType, redis.call( 'HSTRLEN', name, 'data' ), 1 )
+        end
+    end
+    redis.call( 'DEL', KEYS[2] )
+    return 1
+`)
+
+
 type Persistent struct {
-    client      *db.Client
+    client      db.UniversalClient
+    clientName  string
     ctx         context.Context
     timeout     time.Duration
+    database    int
+
+    changes         notifier
+    subscription    *db.PubSub
+    subscribing     sync.Once
+
+    password            *rotatingSecret
+    sentinelPassword    *rotatingSecret
+    rotatable           bool
+
+    // counters are not maintained within a cluster, as an entry and the
+    // counters are not able to be updated by the same script there
+    counted             bool
+    counting            atomic.Bool
+    scriptLoaded        atomic.Bool
+    stopping            context.Context
+    stop                context.CancelFunc
 }
 
 
 func NewPersistentStore( c *configuration.Config ) *Persistent {
-    content, err := os.ReadFile( c.DatabasePassword )
+    password, err := newRotatingSecret( c.DatabasePassword )
     if err != nil {
         log.Error( fmt.Sprintf( "Database password not able to be read: %v", err ) )
         os.Exit( 1 )
     }
-    dbPassword := string( content )
+    sentinelPassword, err := newRotatingSecret( c.DatabaseSentinelPassword )
+    if err != nil {
+        log.Error( fmt.Sprintf( "Database sentinel password not able to be read: %v", err ) )
+        os.Exit( 1 )
+    }
+    dbPassword := password.get()
+
+    options := &db.UniversalOptions{
+        Addrs: []string{ fmt.Sprintf( "%s:%d", c.DatabaseHost, c.DatabasePort ) },
+        Username: c.DatabaseUsername,
+        Password: dbPassword,
+        DB: c.DatabaseName,
+
+        MasterName: c.DatabaseSentinelMaster,
+        SentinelUsername: c.DatabaseSentinelUsername,
+        SentinelPassword: sentinelPassword.get(),
+
+        DialTimeout: c.DatabaseDialTimeout,
+        ReadTimeout: c.DatabaseReadTimeout,
+        WriteTimeout: c.DatabaseWriteTimeout,
+        ContextTimeoutEnabled: true,
+
+        MaxRetries: c.DatabaseMaxRetries,
+        MinRetryBackoff: c.DatabaseMinRetryBackoff,
+        MaxRetryBackoff: c.DatabaseMaxRetryBackoff,
+
+        PoolSize: c.DatabasePoolSize,
+        PoolTimeout: c.DatabasePoolTimeout,
+        MinIdleConns: c.DatabaseMinIdleConns,
+        MaxIdleConns: c.DatabaseMaxIdleConns,
+        MaxActiveConns: c.DatabaseMaxActiveConns,
+        ConnMaxIdleTime: c.DatabaseConnMaxIdleTime,
+        ConnMaxLifetime: c.DatabaseConnMaxLifetime,
+
+        MaxRedirects: c.DatabaseMaxRedirects,
 
+        ClientName: "webservice",
+    }
+
+    network := "tcp"
+    if len( c.DatabaseURL ) >= 1 {
+        parsed, err := db.ParseURL( c.DatabaseURL )
+        if err != nil {
+            log.Error( fmt.Sprintf( "Database URL not able to be parsed: %v", err ) )
+            os.Exit( 1 )
+        }
+        network = parsed.Network
+        options.Addrs = []string{ parsed.Addr }
+        options.DB = parsed.DB
+        if len( parsed.Username ) >= 1 {
+            options.Username = parsed.Username
+        }
+        if len( dbPassword ) <= 0 {
+            options.Password = parsed.Password
+            password.set( parsed.Password )
+        }
+        mergeURLOptions( options, parsed )
+    }
+
+    if options.PoolSize <= 0 {
+        options.PoolSize = 10 * runtime.NumCPU()
+    }
+    if options.MaxActiveConns <= 0 {
+        options.MaxActiveConns = options.PoolSize
+    }
+
+    if c.UsesDatabaseTLS() {
+        files := &certificateFiles{
+            caFile: c.DatabaseTLSCAFile,
+            certFile: c.DatabaseTLSCertFile,
+            keyFile: c.DatabaseTLSKeyFile,
+        }
+        if _, _, err := files.load(); err != nil {
+            log.Error( fmt.Sprintf( "Database TLS files not able to be read: %v", err ) )
+            os.Exit( 1 )
+        }
+        minVersion, _ := c.GetDatabaseTLSMinVersion()
+        options.Dialer = newTLSDialer(
+            files,
+            c.DatabaseTLSServerName,
+            minVersion,
+            options.DialTimeout,
+        )
+    }
+
+    // new connections authenticate with the current content of the password file
+    username := options.Username
+    credentials := func() ( string, string ) {
+        return username, password.get()
+    }
+
+    var client db.UniversalClient
+    rotatable := true
+    counted := true
+    switch {
+    case len( c.DatabaseClusterAddresses ) >= 1:
+        options.Addrs = c.DatabaseClusterAddresses
+        cluster := options.Cluster()
+        cluster.NewClient = func( node *db.Options ) *db.Client {
+            node.CredentialsProvider = credentials
+            return db.NewClient( node )
+        }
+        client = db.NewClusterClient( cluster )
+        counted = false
+    case len( c.DatabaseSentinelMaster ) >= 1:
+        options.Addrs = c.DatabaseSentinelAddresses
+        client = db.NewFailoverClient( options.Failover() )
+        rotatable = false
+    default:
+        simple := options.Simple()
+        simple.Network = network
+        simple.CredentialsProvider = credentials
+        client = db.NewClient( simple )
+    }
+    client.AddHook( commandLogging{} )
+    client.AddHook( commandTracing{} )
+
+    stopping, stop := context.WithCancel( context.Background() )
     return &Persistent{
-        client: db.NewClient( &db.Options{
-            Addr: fmt.Sprintf( "%s:%d", c.DatabaseHost, c.DatabasePort ),
-            Username: c.DatabaseUsername,
-            Password: dbPassword,
-            DB: c.DatabaseName,
+        client: client,
+        clientName: options.ClientName,
+        timeout: c.DatabaseOperationTimeout,
+        database: options.DB,
+        password: password,
+        sentinelPassword: sentinelPassword,
+        rotatable: rotatable,
+        counted: counted,
+        stopping: stopping,
+        stop: stop,
+    }
+}
 
-            DialTimeout: time.Second * 3,
-            ContextTimeoutEnabled: true,
 
-            MaxRetries: 3,
-            MinRetryBackoff: time.Second * 1,
-            MaxRetryBackoff: time.Second * 2,
+// mergeURLOptions applies the query parameters of a connection URL, which
+// take precedence over the corresponding DB_* settings
+func mergeURLOptions( options *db.UniversalOptions, parsed *db.Options ) {
+    if parsed.Protocol >= 1 {
+        options.Protocol = parsed.Protocol
+    }
+    if len( parsed.ClientName ) >= 1 {
+        options.ClientName = parsed.ClientName
+    }
+    if parsed.MaxRetries != 0 {
+        options.MaxRetries = parsed.MaxRetries
+    }
+    if parsed.MinRetryBackoff != 0 {
+        options.MinRetryBackoff = parsed.MinRetryBackoff
+    }
+    if parsed.MaxRetryBackoff != 0 {
+        options.MaxRetryBackoff = parsed.MaxRetryBackoff
+    }
+    if parsed.DialTimeout != 0 {
+        options.DialTimeout = parsed.DialTimeout
+    }
+    if parsed.ReadTimeout != 0 {
+        options.ReadTimeout = parsed.ReadTimeout
+    }
+    if parsed.WriteTimeout != 0 {
+        options.WriteTimeout = parsed.WriteTimeout
+    }
+    if parsed.PoolFIFO {
+        options.PoolFIFO = true
+    }
+    if parsed.PoolSize >= 1 {
+        options.PoolSize = parsed.PoolSize
+    }
+    if parsed.PoolTimeout != 0 {
+        options.PoolTimeout = parsed.PoolTimeout
+    }
+    if parsed.MinIdleConns >= 1 {
+        options.MinIdleConns = parsed.MinIdleConns
+    }
+    if parsed.MaxIdleConns >= 1 {
+        options.MaxIdleConns = parsed.MaxIdleConns
+    }
+    if parsed.MaxActiveConns >= 1 {
+        options.MaxActiveConns = parsed.MaxActiveConns
+    }
+    if parsed.ConnMaxIdleTime != 0 {
+        options.ConnMaxIdleTime = parsed.ConnMaxIdleTime
+    }
+    if parsed.ConnMaxLifetime != 0 {
+        options.ConnMaxLifetime = parsed.ConnMaxLifetime
+    }
+}
 
-            PoolSize: 10 * runtime.NumCPU(),
-            MaxActiveConns: 10 * runtime.NumCPU(),
-        }),
 
-        timeout: time.Second * 20,
+// ReloadCredentials reads the password files again, new connections use the
+// rotated password while established ones stay authenticated
+func ( e *Persistent ) ReloadCredentials() error {
+    password, err := e.password.read()
+    if err != nil {
+        return errors.New(
+            fmt.Sprintf( "Database password not able to be read: %v", err ),
+        )
+    }
+    sentinelPassword, err := e.sentinelPassword.read()
+    if err != nil {
+        return errors.New(
+            fmt.Sprintf( "Database sentinel password not able to be read: %v", err ),
+        )
+    }
+
+    if !e.rotatable && password != e.password.get() {
+        return errors.New(
+            fmt.Sprintln( "Database password changed, with sentinel this requires a restart" ),
+        )
+    }
+    if sentinelPassword != e.sentinelPassword.get() {
+        return errors.New(
+            fmt.Sprintln( "Database sentinel password changed, this requires a restart" ),
+        )
     }
+    e.password.set( password )
+    return nil
+}
+
+
+func ( e *Persistent ) Add( ctx context.Context, i Item ) error {
+    return e.apply( ctx, []mutation{ { name: i.Name(), item: &i } } )
+}
+
+
+func ( e *Persistent ) Remove( ctx context.Context, name string ) error {
+    return e.apply( ctx, []mutation{ { name: name } } )
 }
 
 
-func ( e *Persistent ) Add( i Item ) error {
-    ctx, cancel := context.WithTimeout( context.TODO(), e.timeout )
+// maxUpdateAttempts bounds how often a conditional write is retried as the
+// entry was changed after being read
+const maxUpdateAttempts = 5
+
+
+func ( e *Persistent ) apply( ctx context.Context, mutations []mutation ) error {
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
     defer cancel()
 
-    name := i.Name()
-    if err := e.client.HSet(
-        ctx, name,
-        "mime", i.MimeType(),
-        "data", i.Data(),
-    ).Err(); err != nil {
+    // the counting script is sent once, then referred to by its hash unless
+    // the server lost it, e.g. after a restart
+    loaded := e.scriptLoaded.Load()
+    err := e.pipeline( ctx, mutations, loaded )
+    if loaded && db.HasErrorPrefix( err, "NOSCRIPT" ) {
+        err = e.pipeline( ctx, mutations, false )
+    }
+    if err == nil && e.counted {
+        e.scriptLoaded.Store( true )
+    }
+    return err
+}
+
+
+// requestName is the connection name for the commands issued for the request
+// in ctx, if any, as Redis has no way to attach comments to single commands;
+// it shows in CLIENT LIST and SLOWLOG, but not within a cluster, where
+// commands without keys go to any node
+func ( e *Persistent ) requestName( ctx context.Context ) string {
+    if _, isCluster := e.client.( *db.ClusterClient ); isCluster {
+        return ""
+    }
+    id := logging.RequestId( ctx )
+    if len( id ) <= 0 || len( e.clientName ) <= 0 {
+        return ""
+    }
+    for _, r := range id {
+        if r < '!' || r > '~' {
+            return ""
+        }
+    }
+    return e.clientName + ":" + id
+}
+
+
+func ( e *Persistent ) pipeline( ctx context.Context, mutations []mutation, loaded bool ) error {
+    name := e.requestName( ctx )
+    cmds, err := e.client.Pipelined( ctx, func( pipe db.Pipeliner ) error {
+        if len( name ) >= 1 {
+            pipe.ClientSetName( ctx, name )
+        }
+        e.queue( ctx, pipe, mutations, loaded )
+        if len( name ) >= 1 {
+            pipe.ClientSetName( ctx, e.clientName )
+        }
+        return nil
+    })
+    if len( name ) <= 0 || err == nil {
         return err
     }
+
+    // naming the connection is not vital, e.g. if not permitted
+    for _, cmd := range cmds[ 1 : len( cmds ) - 1 ] {
+        if err := cmd.Err(); err != nil {
+            return err
+        }
+    }
     return nil
 }
 
 
-func ( e *Persistent ) Remove( name string ) error {
-    ctx, cancel := context.WithTimeout( context.TODO(), e.timeout )
+func ( e *Persistent ) queue( ctx context.Context, pipe db.Pipeliner, mutations []mutation, loaded bool ) {
+    count := countingScript.Eval
+    if loaded {
+        count = countingScript.EvalSha
+    }
+
+    for _, m := range mutations {
+        keys := []string{ m.name, statsKey, countingKey }
+        switch {
+        case e.counted && m.item == nil:
+            count( ctx, pipe, keys, "remove" )
+        case e.counted:
+            count( ctx, pipe, keys, "add", m.item.MimeType(), m.item.Data() )
+        case m.item == nil:
+            pipe.Del( ctx, m.name )
+        default:
+            pipe.HSet(
+                ctx, m.name,
+                "mime", m.item.MimeType(),
+                "data", m.item.Data(),
+            )
+        }
+        pipe.Publish( ctx, changesChannel, m.name )
+    }
+}
+
+
+// Update watches the entry while reading it and writes it in a transaction,
+// which fails and is retried if the entry was changed in between
+func ( e *Persistent ) Update( ctx context.Context, name string, item *Item, condition Condition ) error {
+    mutations := []mutation{ { name: name, item: item } }
+    if condition == nil {
+        return e.apply( ctx, mutations )
+    }
+
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
     defer cancel()
 
-    if err := e.client.Del( ctx, name ).Err(); err != nil {
+    // the connection is named outside of the transaction, which would be
+    // aborted if naming it is not permitted
+    requestName := e.requestName( ctx )
+    loaded := e.scriptLoaded.Load()
+    for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+        err := e.client.Watch( ctx, func( tx *db.Tx ) error {
+            if len( requestName ) >= 1 {
+                tx.ClientSetName( ctx, requestName )
+                defer tx.ClientSetName( ctx, e.clientName )
+            }
+
+            value, err := tx.HGetAll( ctx, name ).Result()
+            if err != nil {
+                return err
+            }
+
+            var current *Item = nil
+            if len( value ) >= 1 {
+                i := NewItem( name, value[ "mime" ], []byte( value[ "data" ] ) )
+                current = &i
+            }
+            if err := condition( current ); err != nil {
+                return err
+            }
+
+            _, err = tx.TxPipelined( ctx, func( pipe db.Pipeliner ) error {
+                e.queue( ctx, pipe, mutations, loaded )
+                return nil
+            })
+            return err
+        }, name )
+
+        switch {
+        case errors.Is( err, db.TxFailedErr ):
+            continue
+        case loaded && db.HasErrorPrefix( err, "NOSCRIPT" ):
+            loaded = false
+            continue
+        case err == nil && e.counted:
+            e.scriptLoaded.Store( true )
+        }
         return err
     }
-    return nil
+    return ErrConflict
 }
 
 
-func ( e *Persistent ) Fetch( name string ) ( *Item, error ) {
-    ctx, cancel := context.WithTimeout( context.TODO(), e.timeout )
+func ( e *Persistent ) Fetch( ctx context.Context, name string ) ( *Item, error ) {
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
     defer cancel()
 
     value, err := e.client.HGetAll( ctx, name ).Result()
@@ -98,14 +537,41 @@ func ( e *Persistent ) Fetch( name string ) ( *Item, error ) {
 }
 
 
-func ( e *Persistent ) List() ( []string, error ) {
-    ctx, cancel := context.WithTimeout( context.TODO(), e.timeout )
+func ( e *Persistent ) List( ctx context.Context ) ( []string, error ) {
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
     defer cancel()
 
+    cluster, isCluster := e.client.( *db.ClusterClient )
+    if !isCluster {
+        return scanNames( ctx, e.client )
+    }
+
     var names []string
-    i := e.client.Scan( ctx, 0, "", 0 ).Iterator()
+    mux := sync.Mutex{}
+    err := cluster.ForEachMaster( ctx, func( ctx context.Context, shard *db.Client ) error {
+        shardNames, err := scanNames( ctx, shard )
+        if err != nil {
+            return err
+        }
+        mux.Lock()
+        names = append( names, shardNames... )
+        mux.Unlock()
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return names, nil
+}
+
+
+func scanNames( ctx context.Context, client db.Cmdable ) ( []string, error ) {
+    var names []string
+    i := client.Scan( ctx, 0, "", 0 ).Iterator()
     for i.Next( ctx ){
-        names = append( names, i.Val() )
+        if i.Val() != statsKey && i.Val() != countingKey {
+            names = append( names, i.Val() )
+        }
     }
     if err := i.Err(); err != nil {
         return nil, err
@@ -114,6 +580,362 @@ func ( e *Persistent ) List() ( []string, error ) {
 }
 
 
+// Snapshot reads all entries at once, a transaction reads the scanned
+// entries along with the number of keys, which tells whether entries were
+// created in the meantime and the scan has to be repeated
+func ( e *Persistent ) Snapshot( ctx context.Context ) ( []Item, error ) {
+    if _, isCluster := e.client.( *db.ClusterClient ); isCluster {
+        // transactions are limited to a single slot within a cluster
+        log.Warn( "Snapshot is not point in time within a cluster" )
+        return e.snapshotEntries( ctx )
+    }
+
+    const attempts = 5
+    for attempt := 1; ; attempt++ {
+        items, complete, err := e.snapshot( ctx )
+        if err != nil || complete {
+            return items, err
+        }
+        if attempt >= attempts {
+            log.Warn( "Snapshot is not point in time, entries kept being created", "attempts", attempts )
+            return items, nil
+        }
+    }
+}
+
+
+func ( e *Persistent ) snapshot( ctx context.Context ) ( []Item, bool, error ) {
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
+    defer cancel()
+
+    names, err := scanNames( ctx, e.client )
+    if err != nil {
+        return nil, false, err
+    }
+
+    var keys *db.IntCmd
+    var counters *db.IntCmd
+    values := make( []*db.MapStringStringCmd, len( names ) )
+    _, err = e.client.TxPipelined( ctx, func( pipe db.Pipeliner ) error {
+        keys = pipe.DBSize( ctx )
+        counters = pipe.Exists( ctx, statsKey, countingKey )
+        for i, name := range names {
+            values[ i ] = pipe.HGetAll( ctx, name )
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, false, err
+    }
+
+    items := make( []Item, 0, len( names ) )
+    for i, name := range names {
+        if value := values[ i ].Val(); len( value ) >= 1 {
+            items = append( items, NewItem( name, value[ "mime" ], []byte( value[ "data" ] ) ) )
+        }
+    }
+    // entries removed in the meantime are missing from both
+    complete := int64( len( items ) ) == keys.Val() - counters.Val()
+    return items, complete, nil
+}
+
+
+func ( e *Persistent ) snapshotEntries( ctx context.Context ) ( []Item, error ) {
+    names, err := e.List( ctx )
+    if err != nil {
+        return nil, err
+    }
+    items := make( []Item, 0, len( names ) )
+    for _, name := range names {
+        item, err := e.Fetch( ctx, name )
+        if err != nil {
+            return nil, err
+        }
+        if item != nil {
+            items = append( items, *item )
+        }
+    }
+    return items, nil
+}
+
+
+func ( e *Persistent ) Stats( ctx context.Context ) ( Stats, error ) {
+    if !e.counted {
+        return Stats{}, ErrNotCounted
+    }
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
+    defer cancel()
+
+    values, err := e.client.HGetAll( ctx, statsKey ).Result()
+    if err != nil {
+        return Stats{}, err
+    }
+    if len( values ) <= 0 {
+        e.startCounting()
+        return Stats{}, ErrCounting
+    }
+
+    stats := Stats{ MimeTypes: map[ string ] int64 {} }
+    for field, value := range values {
+        quantity, err := strconv.ParseInt( value, 10, 64 )
+        if err != nil {
+            return Stats{}, errors.New(
+                fmt.Sprintf( "Invalid counter %s: %s", field, value ),
+            )
+        }
+        switch field {
+        case "entries":
+            stats.Entries = quantity
+        case "bytes":
+            stats.Bytes = quantity
+        default:
+            if t, found := strings.CutPrefix( field, "mime:" ); found {
+                stats.MimeTypes[ t ] = quantity
+            }
+        }
+    }
+    return stats, nil
+}
+
+
+// startCounting counts the entries in the background, as counters are
+// missing until then, e.g. after an upgrade; it retries until it succeeds
+// or the store disconnects
+func ( e *Persistent ) startCounting() {
+    if !e.counting.CompareAndSwap( false, true ) {
+        return
+    }
+    go func(){
+        defer e.counting.Store( false )
+        backoff := time.Second
+        for {
+            err := e.countEntries( e.stopping )
+            if err == nil || e.stopping.Err() != nil {
+                return
+            }
+            log.Warn( "Entries not able to be counted", "error", err )
+            select {
+            case <-time.After( backoff ):
+            case <-e.stopping.Done():
+                return
+            }
+            backoff = min( backoff * 2, time.Minute )
+        }
+    }()
+}
+
+
+type scannedEntry struct {
+    mimeType    string
+    size        int64
+}
+
+
+// countEntries scans all entries, entries written meanwhile are recorded by
+// the counting script and counted again when the counters are set
+func ( e *Persistent ) countEntries( ctx context.Context ) error {
+    if counted, err := e.client.Exists( ctx, statsKey ).Result(); err != nil || counted == 1 {
+        return err
+    }
+    if err := e.client.SAdd( ctx, countingKey, "/" ).Err(); err != nil {
+        return err
+    }
+
+    names, err := scanNames( ctx, e.client )
+    if err != nil {
+        return err
+    }
+    scanned := make( map[ string ] scannedEntry, len( names ) )
+    const batchSize = 512
+    for start := 0; start < len( names ); start += batchSize {
+        batch := names[ start : min( start + batchSize, len( names ) ) ]
+        mimeTypes := make( []*db.StringCmd, len( batch ) )
+        sizes := make( []*db.Cmd, len( batch ) )
+        _, err := e.client.Pipelined( ctx, func( pipe db.Pipeliner ) error {
+            for i, name := range batch {
+                mimeTypes[ i ] = pipe.HGet( ctx, name, "mime" )
+                sizes[ i ] = pipe.Do( ctx, "HSTRLEN", name, "data" )
+            }
+            return nil
+        })
+        if err != nil && err != db.Nil {
+            return err
+        }
+        for i, name := range batch {
+            if mimeTypes[ i ].Err() != nil {
+                continue
+            }
+            size, _ := sizes[ i ].Int64()
+            scanned[ name ] = scannedEntry{ mediaType( mimeTypes[ i ].Val() ), size }
+        }
+    }
+
+    for {
+        written, err := e.client.SMembers( ctx, countingKey ).Result()
+        if err != nil {
+            return err
+        }
+        excluded := map[ string ] bool {}
+        for _, name := range written {
+            if name != "/" {
+                excluded[ name ] = true
+            }
+        }
+
+        stats := Stats{ MimeTypes: map[ string ] int64 {} }
+        for name, entry := range scanned {
+            if excluded[ name ] {
+                continue
+            }
+            stats.Entries++
+            stats.Bytes += entry.size
+            stats.MimeTypes[ entry.mimeType ]++
+        }
+        counters := []any{ len( excluded ), "entries", stats.Entries, "bytes", stats.Bytes }
+        for t, quantity := range stats.MimeTypes {
+            counters = append( counters, "mime:" + t, quantity )
+        }
+
+        result, err := initializingScript.Run(
+            ctx, e.client, []string{ statsKey, countingKey }, counters...,
+        ).Int()
+        switch {
+        case err != nil:
+            return err
+        case result == -1:
+            return errors.New( fmt.Sprintln( "Counting interrupted, the marker was removed" ) )
+        case result == 1:
+            return nil
+        }
+    }
+}
+
+
+func ( e *Persistent ) subscribe() {
+    e.subscribing.Do( func(){
+        e.subscription = e.client.Subscribe( context.Background(), changesChannel )
+        go func(){
+            subscribed := false
+            for message := range e.subscription.ChannelWithSubscriptions() {
+                switch m := message.( type ) {
+                case *db.Subscription:
+                    if subscribed {
+                        e.changes.notifyAll()
+                    }
+                    subscribed = true
+                case *db.Message:
+                    e.changes.notify( m.Payload )
+                }
+            }
+        }()
+    })
+}
+
+
+func ( e *Persistent ) Watch( name string ) ( <-chan struct{}, func() ) {
+    e.subscribe()
+    return e.changes.watch( name )
+}
+
+
+func ( e *Persistent ) observe( observer changeObserver ) func() {
+    e.subscribe()
+    return e.changes.observe( observer )
+}
+
+
+func ( e *Persistent ) Ping( ctx context.Context ) error {
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
+    defer cancel()
+
+    return e.client.Ping( ctx ).Err()
+}
+
+
+func ( e *Persistent ) Verify( ctx context.Context ) error {
+    ctx, cancel := context.WithTimeout( ctx, e.timeout )
+    defer cancel()
+
+    if cluster, isCluster := e.client.( *db.ClusterClient ); isCluster {
+        return cluster.ForEachShard( ctx, func( ctx context.Context, shard *db.Client ) error {
+            return shard.Ping( ctx ).Err()
+        })
+    }
+
+    if err := e.client.Ping( ctx ).Err(); err != nil {
+        return err
+    }
+
+    info, err := e.client.Do( ctx, "CLIENT", "INFO" ).Text()
+    if err != nil {
+        log.Debug( "Database selection not verifiable", "error", err )
+        return nil
+    }
+    for _, field := range strings.Fields( info ) {
+        value, found := strings.CutPrefix( field, "db=" )
+        if !found {
+            continue
+        }
+        if value != strconv.Itoa( e.database ) {
+            return errors.New(
+                fmt.Sprintf( "Database %d expected to be selected, got %s", e.database, value ),
+            )
+        }
+    }
+    return nil
+}
+
+
 func ( e *Persistent ) Disconnect() error {
+    e.stop()
+    e.subscribing.Do( func(){} )
+    if e.subscription != nil {
+        if err := e.subscription.Close(); err != nil {
+            log.Warn( "Store failed to unsubscribe", "channel", changesChannel, "error", err )
+        }
+    }
+    e.changes.closeAll()
     return e.client.Close()
-}
\ No newline at end of file
+}
+
+func ( e *Persistent ) Metrics() []Metric {
+    stats := e.client.PoolStats()
+    return []Metric{
+        {
+            Name: "state_redis_pool_hits_total",
+            Help: "The number of times a free connection was found in the pool",
+            Type: "counter",
+            Value: float64( stats.Hits ),
+        },
+        {
+            Name: "state_redis_pool_misses_total",
+            Help: "The number of times a free connection was not found in the pool",
+            Type: "counter",
+            Value: float64( stats.Misses ),
+        },
+        {
+            Name: "state_redis_pool_timeouts_total",
+            Help: "The number of times waiting for a connection of the pool timed out",
+            Type: "counter",
+            Value: float64( stats.Timeouts ),
+        },
+        {
+            Name: "state_redis_pool_connections",
+            Help: "The current number of connections in the pool",
+            Type: "gauge",
+            Value: float64( stats.TotalConns ),
+        },
+        {
+            Name: "state_redis_pool_idle_connections",
+            Help: "The current number of idle connections in the pool",
+            Type: "gauge",
+            Value: float64( stats.IdleConns ),
+        },
+        {
+            Name: "state_redis_pool_stale_connections_total",
+            Help: "The number of stale connections removed from the pool",
+            Type: "counter",
+            Value: float64( stats.StaleConns ),
+        },
+    }
+}
@@ -0,0 +1,636 @@
+package state
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "math/rand"
+    "net/http"
+    ht "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+    log "log/slog"
+    fp "path/filepath"
+
+    "webservice/configuration"
+    "webservice/logging"
+    "webservice/tracing"
+
+    "github.com/alicebob/miniredis/v2"
+    "github.com/alicebob/miniredis/v2/server"
+    "github.com/stretchr/testify/assert"
+    db "github.com/redis/go-redis/v9"
+)
+
+
+func newTestPersistentStore( t *testing.T, server *miniredis.Miniredis ) *Persistent {
+    t.Setenv( "ENV_NAME", "testing" )
+    t.Setenv( "DB_URL", "redis://" + server.Addr() )
+    config, err := configuration.New()
+    assert.Nil( t, err )
+
+    store := NewPersistentStore( config )
+    t.Cleanup( func(){ _ = store.Disconnect() } )
+    return store
+}
+
+
+func TestPersistent( t *testing.T ){
+    server := miniredis.RunT( t )
+    store := newTestPersistentStore( t, server )
+
+    assert.Nil( t, store.Ping( context.Background() ) )
+    assert.Nil( t, store.Verify( context.Background() ) )
+    cancelled, cancel := context.WithCancel( context.Background() )
+    cancel()
+    assert.ErrorIs( t, store.Ping( cancelled ), context.Canceled )
+
+    for _, item := range testItems {
+        assert.Nil( t, store.Add( context.Background(), item ) )
+    }
+
+    names, err := store.List( context.Background() )
+    assert.Nil( t, err )
+    assert.Len( t, names, len( testItems ) )
+
+    for _, item := range testItems {
+        fetched, err := store.Fetch( context.Background(), item.Name() )
+        assert.Nil( t, err )
+        assert.Equal( t, item.MimeType(), fetched.MimeType() )
+        assert.Equal( t, item.Data(), fetched.Data() )
+        assert.Equal( t, item.ETag(), fetched.ETag() )
+    }
+
+    changes, stop := store.Watch( testItems[ 0 ].Name() )
+    defer stop()
+    time.Sleep( time.Millisecond * 50 )
+    assert.Nil( t, store.Remove( context.Background(), testItems[ 0 ].Name() ) )
+    select {
+    case <-changes:
+    case <-time.After( time.Second ):
+        t.Fatal( "removal not observed" )
+    }
+
+    fetched, err := store.Fetch( context.Background(), testItems[ 0 ].Name() )
+    assert.Nil( t, err )
+    assert.Nil( t, fetched )
+}
+
+
+func TestPersistentCacheInvalidation( t *testing.T ){
+    server := miniredis.RunT( t )
+
+    cacheConfig := &configuration.Config{
+        CacheMaxEntries: 16,
+        CacheMaxBytes: 1024,
+        CacheTTL: time.Hour,
+    }
+    replicaA := NewCachedStore( newTestPersistentStore( t, server ), cacheConfig )
+    replicaB := NewCachedStore( newTestPersistentStore( t, server ), cacheConfig )
+
+    item := NewItem( "shared", "text/plain", []byte( "first" ) )
+    assert.Nil( t, replicaA.Add( context.Background(), item ) )
+
+    for _, replica := range []*Cached{ replicaA, replicaB } {
+        fetched, err := replica.Fetch( context.Background(), item.Name() )
+        assert.Nil( t, err )
+        assert.Equal( t, []byte( "first" ), fetched.Data() )
+    }
+
+    time.Sleep( time.Millisecond * 50 )
+    changed := NewItem( item.Name(), item.MimeType(), []byte( "second" ) )
+    assert.Nil( t, replicaB.Add( context.Background(), changed ) )
+
+    assert.Eventually( t, func() bool {
+        fetched, err := replicaA.Fetch( context.Background(), item.Name() )
+        return err == nil && fetched != nil && string( fetched.Data() ) == "second"
+    }, time.Second, time.Millisecond * 5 )
+
+    assert.Nil( t, replicaB.Remove( context.Background(), item.Name() ) )
+    assert.Eventually( t, func() bool {
+        fetched, err := replicaA.Fetch( context.Background(), item.Name() )
+        return err == nil && fetched == nil
+    }, time.Second, time.Millisecond * 5 )
+}
+
+
+func TestPersistentCredentialRotation( t *testing.T ){
+    server := miniredis.RunT( t )
+    server.RequireAuth( "first" )
+    passwordFile := fp.Join( t.TempDir(), "password" )
+    assert.Nil( t, os.WriteFile( passwordFile, []byte( "first" ), 0600 ) )
+    t.Setenv( "DB_PASSWORD", passwordFile )
+    store := newTestPersistentStore( t, server )
+    assert.Nil( t, store.Ping( context.Background() ) )
+
+    assert.Nil( t, os.WriteFile( passwordFile, []byte( "second" ), 0600 ) )
+    server.RequireAuth( "second" )
+    assert.Nil( t, store.ReloadCredentials() )
+
+    server.Close()
+    assert.Nil( t, server.Restart() )
+    assert.Nil( t, store.Ping( context.Background() ) )
+
+    assert.Nil( t, os.Remove( passwordFile ) )
+    assert.NotNil( t, store.ReloadCredentials() )
+    assert.Equal( t, "second", store.password.get() )
+}
+
+
+func TestPersistentCommandLogging( t *testing.T ){
+    server := miniredis.RunT( t )
+    store := newTestPersistentStore( t, server )
+
+    buffer := &bytes.Buffer{}
+    previous := log.Default()
+    log.SetDefault( log.New( logging.NewHandler( "json", buffer, log.LevelDebug ) ) )
+    defer log.SetDefault( previous )
+
+    ctx := logging.WithRequestId( context.Background(), "test-request" )
+    assert.Nil( t, store.Add( ctx, NewItem( "foo", "text/plain", []byte( "bar" ) ) ) )
+    _, err := store.Fetch( ctx, "foo" )
+    assert.Nil( t, err )
+
+    var records []map[ string ] any
+    decoder := json.NewDecoder( buffer )
+    for decoder.More() {
+        record := map[ string ] any {}
+        assert.Nil( t, decoder.Decode( &record ) )
+        records = append( records, record )
+    }
+    assert.Len( t, records, 2 )
+    assert.Equal( t, "client eval publish client", records[ 0 ][ "command" ] )
+    assert.Equal( t, "hgetall", records[ 1 ][ "command" ] )
+    for _, record := range records {
+        assert.Equal( t, "Database command processed", record[ "msg" ] )
+        assert.Equal( t, "test-request", record[ "requestId" ] )
+    }
+}
+
+
+// commandArgs records the arguments of all commands
+type commandArgs struct {
+    mux     sync.Mutex
+    args    []string
+}
+
+func ( h *commandArgs ) DialHook( next db.DialHook ) db.DialHook {
+    return next
+}
+
+func ( h *commandArgs ) ProcessHook( next db.ProcessHook ) db.ProcessHook {
+    return func( ctx context.Context, cmd db.Cmder ) error {
+        h.record( cmd )
+        return next( ctx, cmd )
+    }
+}
+
+func ( h *commandArgs ) ProcessPipelineHook( next db.ProcessPipelineHook ) db.ProcessPipelineHook {
+    return func( ctx context.Context, cmds []db.Cmder ) error {
+        for _, cmd := range cmds {
+            h.record( cmd )
+        }
+        return next( ctx, cmds )
+    }
+}
+
+func ( h *commandArgs ) record( cmd db.Cmder ) {
+    h.mux.Lock()
+    defer h.mux.Unlock()
+    if cmd.Name() == "client" {
+        h.args = append( h.args, strings.TrimSpace( fmt.Sprintln( cmd.Args()... ) ) )
+    } else {
+        h.args = append( h.args, cmd.Name() )
+    }
+}
+
+
+func TestPersistentRequestName( t *testing.T ){
+    server := miniredis.RunT( t )
+    store := newTestPersistentStore( t, server )
+    hook := &commandArgs{}
+    store.client.AddHook( hook )
+
+    // the connection is named after the request while writing for it
+    ctx := logging.WithRequestId( context.Background(), "test-request" )
+    item := testItems[ 0 ]
+    assert.Nil( t, store.Add( ctx, item ) )
+    assert.Nil( t, store.Update( ctx, item.Name(), nil, func( current *Item ) error {
+        return nil
+    }))
+    assert.Nil( t, store.Add( logging.WithRequestId( context.Background(), "no spaces" ), item ) )
+    assert.Equal( t, []string{
+        "client setname webservice:test-request", "eval", "publish", "client setname webservice",
+        "watch", "client setname webservice:test-request", "hgetall",
+        "multi", "evalsha", "publish", "exec", "client setname webservice", "unwatch",
+        "evalsha", "publish",
+    }, hook.args )
+}
+
+
+func TestPersistentCommandTracing( t *testing.T ){
+    spans := make( chan []map[ string ] any, 16 )
+    collector := ht.NewServer( http.HandlerFunc( func( w http.ResponseWriter, req *http.Request ){
+        exported := struct {
+            ResourceSpans []struct {
+                ScopeSpans []struct {
+                    Spans []map[ string ] any `json:"spans"`
+                } `json:"scopeSpans"`
+            } `json:"resourceSpans"`
+        }{}
+        assert.Nil( t, json.NewDecoder( req.Body ).Decode( &exported ) )
+        spans <- exported.ResourceSpans[ 0 ].ScopeSpans[ 0 ].Spans
+    }))
+    defer collector.Close()
+
+    server := miniredis.RunT( t )
+    t.Setenv( "OTLP_ENDPOINT", collector.URL )
+    store := newTestPersistentStore( t, server )
+    config, err := configuration.New()
+    assert.Nil( t, err )
+    tracer := tracing.NewTracer( config )
+    tracer.Start()
+
+    ctx, root := tracer.Trace( context.Background(), tracing.SpanContext{}, "test", tracing.KindInternal )
+    assert.Nil( t, store.Add( ctx, NewItem( "foo", "text/plain", []byte( "bar" ) ) ) )
+    _, err = store.Fetch( ctx, "foo" )
+    assert.Nil( t, err )
+    root.End( nil )
+    tracer.Stop()
+
+    exported := []map[ string ] any {}
+    for len( spans ) >= 1 {
+        exported = append( exported, <-spans... )
+    }
+    assert.Len( t, exported, 3 )
+    assert.Equal( t, "pipeline", exported[ 0 ][ "name" ] )
+    assert.Contains( t, exported[ 0 ][ "attributes" ], map[ string ] any {
+        "key": "db.operation", "value": map[ string ] any { "stringValue": "eval publish" },
+    })
+    assert.Equal( t, "hgetall", exported[ 1 ][ "name" ] )
+    for _, span := range exported[ :2 ] {
+        assert.Equal( t, float64( tracing.KindClient ), span[ "kind" ] )
+        assert.Equal( t, root.Context().TraceId.String(), span[ "traceId" ] )
+        assert.Equal( t, root.Context().SpanId.String(), span[ "parentSpanId" ] )
+        assert.Nil( t, span[ "status" ].( map[ string ] any )[ "code" ] )
+    }
+}
+
+
+// counted waits for the store to count its entries in the background
+func counted( t *testing.T, store Store ) ( Stats, error ) {
+    var stats Stats
+    var err error
+    assert.Eventually( t, func() bool {
+        stats, err = Count( context.Background(), store )
+        return !errors.Is( err, ErrCounting )
+    }, time.Second * 5, time.Millisecond * 10 )
+    return stats, err
+}
+
+
+func TestPersistentStats( t *testing.T ){
+    server := miniredis.RunT( t )
+    ctx := context.Background()
+
+    // entries written before counting started are counted once
+    server.HSet( "existing", "mime", "text/plain; charset=utf-8", "data", "existing" )
+    store := newTestPersistentStore( t, server )
+    _, err := Count( ctx, store )
+    assert.ErrorIs( t, err, ErrCounting )
+    stats, err := counted( t, store )
+    assert.Nil( t, err )
+    assert.Equal( t, Stats{
+        Entries: 1,
+        Bytes: 8,
+        MimeTypes: map[ string ] int64 { "text/plain": 1 },
+    }, stats )
+
+    for _, item := range testItems {
+        assert.Nil( t, store.Add( ctx, item ) )
+    }
+    assert.Nil( t, store.Add( ctx, NewItem( "foo", "Text/HTML", []byte( "replaced" ) ) ) )
+    assert.Nil( t, store.Remove( ctx, "Som!_🎵nam3" ) )
+    assert.Nil( t, store.Remove( ctx, "missing" ) )
+
+    stats, err = Count( ctx, store )
+    assert.Nil( t, err )
+    assert.Equal( t, Stats{
+        Entries: 3,
+        Bytes: 16,
+        MimeTypes: map[ string ] int64 { "text/plain": 1, "text/html": 2 },
+    }, stats )
+
+    names, err := store.List( ctx )
+    assert.Nil( t, err )
+    assert.ElementsMatch( t, []string{ "existing", "foo", "qwertyASDFGH" }, names )
+}
+
+
+// beforeCommand runs a function right before the first command of a name
+// which is not pipelined, and records the names of all commands
+type beforeCommand struct {
+    mux     sync.Mutex
+    run     map[ string ] func()
+    names   []string
+}
+
+func ( h *beforeCommand ) DialHook( next db.DialHook ) db.DialHook {
+    return next
+}
+
+func ( h *beforeCommand ) ProcessHook( next db.ProcessHook ) db.ProcessHook {
+    return func( ctx context.Context, cmd db.Cmder ) error {
+        h.before( cmd, true )
+        return next( ctx, cmd )
+    }
+}
+
+func ( h *beforeCommand ) ProcessPipelineHook( next db.ProcessPipelineHook ) db.ProcessPipelineHook {
+    return func( ctx context.Context, cmds []db.Cmder ) error {
+        for _, cmd := range cmds {
+            h.before( cmd, false )
+        }
+        return next( ctx, cmds )
+    }
+}
+
+func ( h *beforeCommand ) before( cmd db.Cmder, single bool ) {
+    h.mux.Lock()
+    h.names = append( h.names, cmd.Name() )
+    run := h.run[ cmd.Name() ]
+    if single {
+        delete( h.run, cmd.Name() )
+    }
+    h.mux.Unlock()
+    if single && run != nil {
+        run()
+    }
+}
+
+
+func TestPersistentCountingWrites( t *testing.T ){
+    server := miniredis.RunT( t )
+    ctx := context.Background()
+    server.HSet( "existing", "mime", "text/plain", "data", "existing" )
+    server.HSet( "replaced", "mime", "text/plain", "data", "old" )
+    server.HSet( "removed", "mime", "text/html", "data", "x" )
+    store := newTestPersistentStore( t, server )
+
+    // writes after the scan are recorded, a write after they were read
+    // makes setting the counters being retried
+    hook := &beforeCommand{ run: map[ string ] func() {
+        "smembers": func(){
+            assert.Nil( t, store.Add( ctx, NewItem( "created", "text/plain", []byte( "new" ) ) ) )
+            assert.Nil( t, store.Add( ctx, NewItem( "replaced", "text/html", []byte( "newer" ) ) ) )
+            assert.Nil( t, store.Remove( ctx, "removed" ) )
+        },
+        "evalsha": func(){
+            assert.Nil( t, store.Add( ctx, NewItem( "late", "application/json", []byte( "{}" ) ) ) )
+        },
+    }}
+    store.client.AddHook( hook )
+
+    assert.Nil( t, store.countEntries( ctx ) )
+    assert.Empty( t, hook.run )
+    assert.Equal( t, 2, strings.Count( strings.Join( hook.names, " " ), "smembers" ) )
+    assert.False( t, server.Exists( countingKey ) )
+
+    stats, err := store.Stats( ctx )
+    assert.Nil( t, err )
+    assert.Equal( t, Stats{
+        Entries: 4,
+        Bytes: 18,
+        MimeTypes: map[ string ] int64 { "text/plain": 2, "text/html": 1, "application/json": 1 },
+    }, stats )
+
+    assert.Nil( t, store.Remove( ctx, "late" ) )
+    stats, err = store.Stats( ctx )
+    assert.Nil( t, err )
+    assert.Equal( t, int64( 3 ), stats.Entries )
+    assert.Nil( t, store.countEntries( ctx ) )
+    assert.False( t, server.Exists( countingKey ) )
+}
+
+
+func TestPersistentScriptFlushed( t *testing.T ){
+    server := miniredis.RunT( t )
+    store := newTestPersistentStore( t, server )
+    ctx := context.Background()
+    hook := &beforeCommand{}
+    store.client.AddHook( hook )
+
+    assert.Nil( t, store.Add( ctx, testItems[ 0 ] ) )
+    assert.Nil( t, store.Add( ctx, testItems[ 1 ] ) )
+    assert.Nil( t, store.client.ScriptFlush( ctx ).Err() )
+    assert.Nil( t, store.Add( ctx, testItems[ 2 ] ) )
+    assert.Nil( t, store.Add( ctx, testItems[ 0 ] ) )
+    assert.Equal( t, []string{
+        "eval", "publish",
+        "evalsha", "publish",
+        "script",
+        "evalsha", "publish", "eval", "publish",
+        "evalsha", "publish",
+    }, hook.names )
+
+    names, err := store.List( ctx )
+    assert.Nil( t, err )
+    assert.Len( t, names, len( testItems ) )
+}
+
+
+func TestPersistentUpdate( t *testing.T ){
+    server := miniredis.RunT( t )
+    store := newTestPersistentStore( t, server )
+    ctx := context.Background()
+
+    // a write between reading and writing the entry makes the update retried
+    item := testItems[ 0 ]
+    var seen []string
+    assert.Nil( t, store.Update( ctx, item.Name(), &item, func( current *Item ) error {
+        if current == nil {
+            seen = append( seen, "" )
+            concurrent := NewItem( item.Name(), "text/plain", []byte( "concurrent" ) )
+            assert.Nil( t, store.Add( ctx, concurrent ) )
+        } else {
+            seen = append( seen, string( current.Data() ) )
+        }
+        return nil
+    }))
+    assert.Equal( t, []string{ "", "concurrent" }, seen )
+    fetched, err := store.Fetch( ctx, item.Name() )
+    assert.Nil( t, err )
+    assert.Equal( t, item.Data(), fetched.Data() )
+
+    assert.ErrorIs( t, store.Update( ctx, item.Name(), &item, func( current *Item ) error {
+        return store.Add( ctx, item )
+    }), ErrConflict )
+
+    rejected := errors.New( "rejected" )
+    assert.Equal( t, rejected, store.Update( ctx, item.Name(), nil, func( current *Item ) error {
+        return rejected
+    }))
+    assert.Nil( t, store.Update( ctx, item.Name(), nil, func( current *Item ) error {
+        return nil
+    }))
+    fetched, err = store.Fetch( ctx, item.Name() )
+    assert.Nil( t, err )
+    assert.Nil( t, fetched )
+
+    stats, err := counted( t, store )
+    assert.Nil( t, err )
+    assert.Equal( t, int64( 0 ), stats.Entries )
+}
+
+
+// interleaving runs a write right before the first transaction, as if it
+// happened between scanning and reading the entries
+type interleaving struct {
+    write   func()
+}
+
+func ( h *interleaving ) DialHook( next db.DialHook ) db.DialHook {
+    return next
+}
+
+func ( h *interleaving ) ProcessHook( next db.ProcessHook ) db.ProcessHook {
+    return next
+}
+
+func ( h *interleaving ) ProcessPipelineHook( next db.ProcessPipelineHook ) db.ProcessPipelineHook {
+    return func( ctx context.Context, cmds []db.Cmder ) error {
+        if h.write != nil && cmds[ 0 ].Name() == "multi" {
+            h.write()
+            h.write = nil
+        }
+        return next( ctx, cmds )
+    }
+}
+
+
+func TestPersistentSnapshot( t *testing.T ){
+    server := miniredis.RunT( t )
+    store := newTestPersistentStore( t, server )
+    ctx := context.Background()
+
+    for _, item := range testItems {
+        assert.Nil( t, store.Add( ctx, item ) )
+    }
+    _, err := counted( t, store )
+    assert.Nil( t, err )
+
+    hook := &interleaving{ write: func(){
+        server.HSet( "created", "mime", "text/plain", "data", "late" )
+        server.Del( testItems[ 0 ].Name() )
+    }}
+    store.client.AddHook( hook )
+
+    items, err := Snapshot( ctx, store )
+    assert.Nil( t, err )
+    assert.Nil( t, hook.write )
+    names := []string{}
+    for _, item := range items {
+        names = append( names, item.Name() )
+    }
+    assert.ElementsMatch( t, []string{ testItems[ 1 ].Name(), testItems[ 2 ].Name(), "created" }, names )
+}
+
+
+func TestPersistentURLOptions( t *testing.T ){
+    t.Setenv( "ENV_NAME", "testing" )
+    t.Setenv( "DB_URL", "redis://localhost:6379/2?dial_timeout=1s&pool_size=4&protocol=2&client_name=replica" )
+    t.Setenv( "DB_READ_TIMEOUT", "5s" )
+    config, err := configuration.New()
+    assert.Nil( t, err )
+    store := NewPersistentStore( config )
+    t.Cleanup( func(){ _ = store.Disconnect() } )
+
+    options := store.client.( *db.Client ).Options()
+    assert.Equal( t, 2, options.DB )
+    assert.Equal( t, time.Second, options.DialTimeout )
+    assert.Equal( t, time.Second * 5, options.ReadTimeout )
+    assert.Equal( t, 4, options.PoolSize )
+    assert.Equal( t, 4, options.MaxActiveConns )
+    assert.Equal( t, 2, options.Protocol )
+    assert.Equal( t, "replica", options.ClientName )
+}
+
+
+// sentinel stands in for a Redis Sentinel monitoring the master server
+func sentinel( t *testing.T, master *miniredis.Miniredis ) *miniredis.Miniredis {
+    s := miniredis.RunT( t )
+    err := s.Server().Register( "SENTINEL", func( c *server.Peer, cmd string, args []string ){
+        switch strings.ToLower( args[ 0 ] ) {
+        case "get-master-addr-by-name":
+            c.WriteStrings( []string{ master.Host(), master.Port() } )
+        default:
+            c.WriteLen( 0 )
+        }
+    })
+    assert.Nil( t, err )
+    return s
+}
+
+
+// runLowPort starts a server on a port DB_PORT is able to hold, as ephemeral
+// ports usually exceed it
+func runLowPort( t *testing.T ) *miniredis.Miniredis {
+    server := miniredis.NewMiniRedis()
+    for attempt := 0; attempt < 32; attempt++ {
+        address := fmt.Sprintf( "127.0.0.1:%d", 10000 + rand.Intn( 20000 ) )
+        if err := server.StartAddr( address ); err == nil {
+            t.Cleanup( server.Close )
+            return server
+        }
+    }
+    t.Fatal( "no port available" )
+    return nil
+}
+
+
+func TestPersistentClientSelection( t *testing.T ){
+    master := runLowPort( t )
+    modes := []struct{
+        name        string
+        settings    map[ string ] string
+        cluster     bool
+        rotatable   bool
+    }{
+        { "host", map[ string ] string { "DB_HOST": master.Host(), "DB_PORT": master.Port() }, false, true },
+        { "url", map[ string ] string { "DB_URL": "redis://" + master.Addr() }, false, true },
+        { "sentinel", map[ string ] string {
+            "DB_SENTINEL_MASTER": "main",
+            "DB_SENTINEL_ADDRS": sentinel( t, master ).Addr(),
+        }, false, false },
+        { "cluster", map[ string ] string { "DB_CLUSTER_ADDRS": master.Addr() }, true, true },
+    }
+
+    for _, mode := range modes {
+        t.Run( mode.name, func( t *testing.T ){
+            t.Setenv( "ENV_NAME", "testing" )
+            for key, value := range mode.settings {
+                t.Setenv( key, value )
+            }
+            config, err := configuration.New()
+            if !assert.Nil( t, err ) {
+                return
+            }
+            store := NewPersistentStore( config )
+            t.Cleanup( func(){ _ = store.Disconnect() } )
+
+            _, isCluster := store.client.( *db.ClusterClient )
+            assert.Equal( t, mode.cluster, isCluster )
+            assert.Equal( t, !mode.cluster, store.counted )
+            assert.Equal( t, mode.rotatable, store.rotatable )
+
+            item := NewItem( "selection-" + mode.name, "text/plain", []byte( mode.name ) )
+            assert.Nil( t, store.Add( context.Background(), item ) )
+            assert.Equal( t, mode.name, master.HGet( item.Name(), "data" ) )
+        })
+    }
+}
@@ -0,0 +1,43 @@
+package state
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+    log "log/slog"
+)
+
+
+type verifier interface {
+    Verify( ctx context.Context ) error
+}
+
+
+func Await( ctx context.Context, store Store, backoff time.Duration ) ( int, error ) {
+    verify := func( ctx context.Context ) error {
+        return store.Ping( ctx )
+    }
+    if v, ok := store.( verifier ); ok {
+        verify = v.Verify
+    }
+
+    attempts := 0
+    for {
+        attempts++
+        err := verify( ctx )
+        if err == nil {
+            return attempts, nil
+        }
+        log.Debug( "Store not available yet", "attempt", attempts, "error", err )
+
+        select {
+        case <-ctx.Done():
+            return attempts, errors.New(
+                fmt.Sprintf( "Store not available after %d attempts: %v", attempts, err ),
+            )
+        case <-time.After( backoff ):
+        }
+        backoff = min( backoff * 2, time.Second * 10 )
+    }
+}
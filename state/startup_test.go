@@ -0,0 +1,26 @@
+package state
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func TestAwait( t *testing.T ){
+    es := NewEphemeralStore()
+
+    attempts, err := Await( context.Background(), es, time.Millisecond )
+    assert.Nil( t, err )
+    assert.Equal( t, 1, attempts )
+
+    es.Disconnect()
+    ctx, cancel := context.WithTimeout( context.Background(), time.Millisecond * 50 )
+    defer cancel()
+    attempts, err = Await( ctx, es, time.Millisecond )
+    assert.NotNil( t, err )
+    assert.Greater( t, attempts, 1 )
+    assert.Contains( t, err.Error(), "ephemeral storage not available" )
+}
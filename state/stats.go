@@ -0,0 +1,78 @@
+package state
+
+import (
+    "context"
+    "errors"
+    "strings"
+)
+
+
+var (
+    ErrNotCounted   = errors.New( "Store does not keep count of its entries" )
+    ErrCounting     = errors.New( "Store is counting its entries" )
+)
+
+
+type Stats struct {
+    Entries     int64
+    Bytes       int64
+
+    // MimeTypes holds the number of entries per media type, nil if unknown
+    MimeTypes   map[ string ] int64
+}
+
+
+// Counted stores keep track of their entries as they change, so that
+// reporting them does not require to list all entries
+type Counted interface {
+    Stats( ctx context.Context ) ( Stats, error )
+}
+
+
+// Count reports the entries of a store, by listing them if the store does
+// not keep count, in which case neither their size nor types are known;
+// ErrCounting is returned while the store counts them for the first time
+func Count( ctx context.Context, store Store ) ( Stats, error ) {
+    if counted, ok := store.( Counted ); ok {
+        stats, err := counted.Stats( ctx )
+        if !errors.Is( err, ErrNotCounted ) {
+            return stats, err
+        }
+    }
+
+    names, err := store.List( ctx )
+    if err != nil {
+        return Stats{}, err
+    }
+    return Stats{ Entries: int64( len( names ) ) }, nil
+}
+
+
+// mediaType strips the parameters off a MIME type, the counterpart in Lua
+// is part of the counting script of Persistent
+func mediaType( mimeType string ) string {
+    t, _, _ := strings.Cut( mimeType, ";" )
+    return strings.ToLower( strings.TrimSpace( t ) )
+}
+
+
+func ( s *Stats ) add( i *Item, sign int64 ) {
+    s.Entries += sign
+    s.Bytes += sign * int64( len( i.Data() ) )
+
+    t := mediaType( i.MimeType() )
+    s.MimeTypes[ t ] += sign
+    if s.MimeTypes[ t ] <= 0 {
+        delete( s.MimeTypes, t )
+    }
+}
+
+
+func ( s *Stats ) clone() Stats {
+    clone := *s
+    clone.MimeTypes = make( map[ string ] int64, len( s.MimeTypes ) )
+    for t, quantity := range s.MimeTypes {
+        clone.MimeTypes[ t ] = quantity
+    }
+    return clone
+}
@@ -1,12 +1,71 @@
 package state
 
+import (
+    "context"
+    "errors"
+)
+
+
+var ErrConflict = errors.New( "Entry kept being changed concurrently" )
+
+
+// Condition decides whether a conditional write goes ahead, given the
+// current entry or nil if there is none; an error aborts the write and is
+// returned as is, a nil Condition accepts any entry
+type Condition func( current *Item ) error
 
 
 type Store interface {
-    Add( i Item ) error
-    Remove( name string ) error
-    Fetch( name string ) ( *Item, error )
-    List() ( []string, error )
+    Add( ctx context.Context, i Item ) error
+    Remove( ctx context.Context, name string ) error
+    // Update writes item, or removes the entry if item is nil, once
+    // condition accepted the current entry, with no other write in between
+    Update( ctx context.Context, name string, item *Item, condition Condition ) error
+    Fetch( ctx context.Context, name string ) ( *Item, error )
+    List( ctx context.Context ) ( []string, error )
+    Watch( name string ) ( <-chan struct{}, func() )
+    Ping( ctx context.Context ) error
 
     Disconnect() error
 }
+
+
+
+type Metric struct {
+    Name    string
+    Help    string
+    Type    string
+    Value   float64
+}
+
+type Instrumented interface {
+    Metrics() []Metric
+}
+
+
+type Snapshotter interface {
+    Snapshot( ctx context.Context ) ( []Item, error )
+}
+
+
+func Snapshot( ctx context.Context, store Store ) ( []Item, error ) {
+    if snapshotter, ok := store.( Snapshotter ); ok {
+        return snapshotter.Snapshot( ctx )
+    }
+
+    names, err := store.List( ctx )
+    if err != nil {
+        return nil, err
+    }
+    items := make( []Item, 0, len( names ) )
+    for _, name := range names {
+        item, err := store.Fetch( ctx, name )
+        if err != nil {
+            return nil, err
+        }
+        if item != nil {
+            items = append( items, *item )
+        }
+    }
+    return items, nil
+}
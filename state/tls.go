@@ -0,0 +1,157 @@
+package state
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "fmt"
+    "net"
+    "os"
+    "sync"
+    "time"
+    log "log/slog"
+)
+
+
+type dialer func( ctx context.Context, network string, addr string ) ( net.Conn, error )
+
+
+type certificateFiles struct {
+    caFile      string
+    certFile    string
+    keyFile     string
+
+    caVersion       string
+    certVersion     string
+    rootCAs         *x509.CertPool
+    certificate     *tls.Certificate
+    mux             sync.Mutex
+}
+
+
+func fileVersion( paths ...string ) ( string, error ) {
+    version := ""
+    for _, path := range paths {
+        info, err := os.Stat( path )
+        if err != nil {
+            return "", err
+        }
+        version += fmt.Sprintf( "%d:%d;", info.ModTime().UnixNano(), info.Size() )
+    }
+    return version, nil
+}
+
+
+func ( f *certificateFiles ) load() ( *x509.CertPool, *tls.Certificate, error ) {
+    f.mux.Lock()
+    defer f.mux.Unlock()
+
+    if len( f.caFile ) >= 1 {
+        if err := f.loadAuthority(); err != nil {
+            if f.rootCAs == nil {
+                return nil, nil, err
+            }
+            log.Warn( "Database TLS CA not reloaded", "file", f.caFile, "error", err )
+        }
+    }
+
+    if len( f.certFile ) >= 1 {
+        if err := f.loadCertificate(); err != nil {
+            if f.certificate == nil {
+                return nil, nil, err
+            }
+            log.Warn( "Database TLS certificate not reloaded", "file", f.certFile, "error", err )
+        }
+    }
+
+    return f.rootCAs, f.certificate, nil
+}
+
+
+func ( f *certificateFiles ) loadAuthority() error {
+    version, err := fileVersion( f.caFile )
+    if err != nil {
+        return err
+    }
+    if version == f.caVersion {
+        return nil
+    }
+
+    content, err := os.ReadFile( f.caFile )
+    if err != nil {
+        return err
+    }
+    pool := x509.NewCertPool()
+    if ! pool.AppendCertsFromPEM( content ) {
+        return errors.New(
+            fmt.Sprintf( "No certificates found in %s", f.caFile ),
+        )
+    }
+
+    f.rootCAs = pool
+    f.caVersion = version
+    return nil
+}
+
+
+func ( f *certificateFiles ) loadCertificate() error {
+    version, err := fileVersion( f.certFile, f.keyFile )
+    if err != nil {
+        return err
+    }
+    if version == f.certVersion {
+        return nil
+    }
+
+    certificate, err := tls.LoadX509KeyPair( f.certFile, f.keyFile )
+    if err != nil {
+        return err
+    }
+
+    f.certificate = &certificate
+    f.certVersion = version
+    return nil
+}
+
+
+func newTLSDialer(
+    files *certificateFiles,
+    serverName string,
+    minVersion uint16,
+    timeout time.Duration,
+) dialer {
+    netDialer := &net.Dialer{
+        Timeout: timeout,
+        KeepAlive: time.Minute * 5,
+    }
+
+    return func( ctx context.Context, network string, addr string ) ( net.Conn, error ) {
+        rootCAs, certificate, err := files.load()
+        if err != nil {
+            return nil, err
+        }
+
+        config := &tls.Config{
+            MinVersion: minVersion,
+            ServerName: serverName,
+            RootCAs: rootCAs,
+        }
+        if len( config.ServerName ) <= 0 {
+            host, _, err := net.SplitHostPort( addr )
+            if err != nil {
+                return nil, err
+            }
+            config.ServerName = host
+        }
+        if certificate != nil {
+            config.Certificates = []tls.Certificate{ *certificate }
+        }
+
+        tlsDialer := &tls.Dialer{
+            NetDialer: netDialer,
+            Config: config,
+        }
+        return tlsDialer.DialContext( ctx, network, addr )
+    }
+}
@@ -0,0 +1,81 @@
+package state
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "math/big"
+    "os"
+    "testing"
+    "time"
+    fp "path/filepath"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func writeCertificate( t *testing.T, certFile string, keyFile string, commonName string ){
+    key, err := ecdsa.GenerateKey( elliptic.P256(), rand.Reader )
+    assert.Nil( t, err )
+
+    template := &x509.Certificate{
+        SerialNumber: big.NewInt( time.Now().UnixNano() ),
+        Subject: pkix.Name{ CommonName: commonName },
+        NotBefore: time.Now().Add( -time.Hour ),
+        NotAfter: time.Now().Add( time.Hour ),
+        IsCA: true,
+        BasicConstraintsValid: true,
+    }
+    der, err := x509.CreateCertificate( rand.Reader, template, template, &key.PublicKey, key )
+    assert.Nil( t, err )
+    keyDer, err := x509.MarshalECPrivateKey( key )
+    assert.Nil( t, err )
+
+    certPem := pem.EncodeToMemory( &pem.Block{ Type: "CERTIFICATE", Bytes: der } )
+    keyPem := pem.EncodeToMemory( &pem.Block{ Type: "EC PRIVATE KEY", Bytes: keyDer } )
+    assert.Nil( t, os.WriteFile( certFile, certPem, 0600 ) )
+    assert.Nil( t, os.WriteFile( keyFile, keyPem, 0600 ) )
+}
+
+
+func TestCertificateFilesReload( t *testing.T ){
+    dir := t.TempDir()
+    certFile := fp.Join( dir, "tls.crt" )
+    keyFile := fp.Join( dir, "tls.key" )
+
+    files := &certificateFiles{
+        caFile: certFile,
+        certFile: certFile,
+        keyFile: keyFile,
+    }
+
+    _, _, err := files.load()
+    assert.NotNil( t, err )
+
+    writeCertificate( t, certFile, keyFile, "first" )
+    rootCAs, certificate, err := files.load()
+    assert.Nil( t, err )
+    assert.NotNil( t, rootCAs )
+    firstLeaf, err := x509.ParseCertificate( certificate.Certificate[ 0 ] )
+    assert.Nil( t, err )
+    assert.Equal( t, "first", firstLeaf.Subject.CommonName )
+
+    writeCertificate( t, certFile, keyFile, "second" )
+    future := time.Now().Add( time.Minute )
+    assert.Nil( t, os.Chtimes( certFile, future, future ) )
+    _, certificate, err = files.load()
+    assert.Nil( t, err )
+    secondLeaf, err := x509.ParseCertificate( certificate.Certificate[ 0 ] )
+    assert.Nil( t, err )
+    assert.Equal( t, "second", secondLeaf.Subject.CommonName )
+
+    assert.Nil( t, os.WriteFile( keyFile, []byte( "broken" ), 0600 ) )
+    _, certificate, err = files.load()
+    assert.Nil( t, err )
+    keptLeaf, err := x509.ParseCertificate( certificate.Certificate[ 0 ] )
+    assert.Nil( t, err )
+    assert.Equal( t, "second", keptLeaf.Subject.CommonName )
+}
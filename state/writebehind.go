@@ -0,0 +1,487 @@
+package state
+
+import (
+    "context"
+    "errors"
+    "sync"
+    "sync/atomic"
+    "time"
+    log "log/slog"
+
+    "webservice/configuration"
+)
+
+
+type mutation struct {
+    name    string
+    item    *Item
+}
+
+type batchWriter interface {
+    apply( ctx context.Context, mutations []mutation ) error
+}
+
+
+// errDisconnected rejects writes after the buffer was flushed a last time
+var errDisconnected = errors.New( "write behind store disconnected" )
+
+// errBuffered aborts a conditional write as the entry was buffered after
+// being flushed
+var errBuffered = errors.New( "write behind entry buffered meanwhile" )
+
+
+type WriteBehind struct {
+    backend     Store
+
+    batchSize   int
+    maxBytes    int64
+    interval    time.Duration
+
+    pending         map[ string ] mutation
+    inflight        map[ string ] mutation
+    pendingBytes    int64
+    disconnected    bool
+    mux             sync.Mutex
+    flushing        sync.Mutex
+
+    changes     notifier
+    kick        chan struct{}
+    stop        chan struct{}
+    stopped     chan struct{}
+    stopping    sync.Once
+
+    acknowledged    atomic.Uint64
+    flushed         atomic.Uint64
+    flushes         atomic.Uint64
+    flushErrors     atomic.Uint64
+}
+
+
+func NewWriteBehindStore( backend Store, c *configuration.Config ) *WriteBehind {
+    w := &WriteBehind{
+        backend: backend,
+        batchSize: c.WriteBehindBatchSize,
+        maxBytes: c.WriteBehindMaxBytes,
+        interval: c.WriteBehindInterval,
+        pending: map[ string ] mutation {},
+        inflight: map[ string ] mutation {},
+        kick: make( chan struct{}, 1 ),
+        stop: make( chan struct{} ),
+        stopped: make( chan struct{} ),
+    }
+    go w.run()
+    return w
+}
+
+
+func ( w *WriteBehind ) run() {
+    defer close( w.stopped )
+
+    ticker := time.NewTicker( w.interval )
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ticker.C:
+        case <-w.kick:
+        case <-w.stop:
+            return
+        }
+        if err := w.Flush(); err != nil {
+            log.Warn( "Write behind failed to flush", "error", err )
+        }
+    }
+}
+
+
+func ( w *WriteBehind ) enqueue( ctx context.Context, m mutation ) error {
+    size := entrySize( m.item )
+    if size > w.maxBytes {
+        w.flushing.Lock()
+        defer w.flushing.Unlock()
+
+        w.mux.Lock()
+        if w.disconnected {
+            w.mux.Unlock()
+            return errDisconnected
+        }
+        if previous, found := w.pending[ m.name ]; found {
+            w.pendingBytes -= entrySize( previous.item )
+            delete( w.pending, m.name )
+        }
+        w.mux.Unlock()
+
+        if err := w.write( ctx, []mutation{ m } ); err != nil {
+            return err
+        }
+        w.changes.notify( m.name )
+        return nil
+    }
+
+    for {
+        w.mux.Lock()
+        if w.disconnected {
+            w.mux.Unlock()
+            return errDisconnected
+        }
+        if w.pendingBytes + size <= w.maxBytes {
+            break
+        }
+        w.mux.Unlock()
+
+        if err := w.Flush(); err != nil {
+            return err
+        }
+    }
+
+    if previous, found := w.pending[ m.name ]; found {
+        w.pendingBytes -= entrySize( previous.item )
+    }
+    w.pending[ m.name ] = m
+    w.pendingBytes += size
+    full := len( w.pending ) >= w.batchSize
+    w.mux.Unlock()
+
+    w.acknowledged.Add( 1 )
+    w.changes.notify( m.name )
+
+    if full {
+        signal( w.kick )
+    }
+    return nil
+}
+
+
+func ( w *WriteBehind ) write( ctx context.Context, mutations []mutation ) error {
+    if writer, ok := w.backend.( batchWriter ); ok {
+        return writer.apply( ctx, mutations )
+    }
+
+    for _, m := range mutations {
+        var err error
+        if m.item == nil {
+            err = w.backend.Remove( ctx, m.name )
+        } else {
+            err = w.backend.Add( ctx, *m.item )
+        }
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+
+func ( w *WriteBehind ) Flush() error {
+    w.flushing.Lock()
+    defer w.flushing.Unlock()
+
+    w.mux.Lock()
+    if len( w.pending ) <= 0 {
+        w.mux.Unlock()
+        return nil
+    }
+    w.inflight, w.pending = w.pending, map[ string ] mutation {}
+    batch := make( []mutation, 0, len( w.inflight ) )
+    for _, m := range w.inflight {
+        batch = append( batch, m )
+    }
+    w.mux.Unlock()
+
+    // a backlog, e.g. built up while the backend was not reachable, is
+    // written in batches of bounded size
+    written := 0
+    var err error
+    for written < len( batch ) {
+        chunk := batch[ written : min( written + w.batchSize, len( batch ) ) ]
+        w.flushes.Add( 1 )
+        if err = w.write( context.Background(), chunk ); err != nil {
+            w.flushErrors.Add( 1 )
+            break
+        }
+        written += len( chunk )
+    }
+
+    w.mux.Lock()
+    defer w.mux.Unlock()
+
+    for _, m := range batch[ :written ] {
+        w.pendingBytes -= entrySize( m.item )
+    }
+    w.flushed.Add( uint64( written ) )
+    for _, m := range batch[ written: ] {
+        if _, found := w.pending[ m.name ]; !found {
+            w.pending[ m.name ] = m
+        } else {
+            w.pendingBytes -= entrySize( m.item )
+        }
+    }
+    w.inflight = map[ string ] mutation {}
+    return err
+}
+
+
+func ( w *WriteBehind ) buffered( name string ) ( mutation, bool ) {
+    w.mux.Lock()
+    defer w.mux.Unlock()
+
+    if m, found := w.pending[ name ]; found {
+        return m, true
+    }
+    m, found := w.inflight[ name ]
+    return m, found
+}
+
+
+func ( w *WriteBehind ) Add( ctx context.Context, i Item ) error {
+    return w.enqueue( ctx, mutation{ name: i.Name(), item: &i } )
+}
+
+
+func ( w *WriteBehind ) Remove( ctx context.Context, name string ) error {
+    return w.enqueue( ctx, mutation{ name: name } )
+}
+
+
+// Update writes through to the backend if a condition has to hold, as the
+// condition has to see the stored entry, the entry is flushed first if
+// buffered
+func ( w *WriteBehind ) Update( ctx context.Context, name string, item *Item, condition Condition ) error {
+    if condition == nil {
+        return w.enqueue( ctx, mutation{ name: name, item: item } )
+    }
+
+    for attempt := 0; attempt < maxUpdateAttempts; attempt++ {
+        if _, found := w.buffered( name ); found {
+            if err := w.Flush(); err != nil {
+                return err
+            }
+            continue
+        }
+
+        err := w.backend.Update( ctx, name, item, func( current *Item ) error {
+            w.mux.Lock()
+            defer w.mux.Unlock()
+
+            if w.disconnected {
+                return errDisconnected
+            }
+            _, pending := w.pending[ name ]
+            _, inflight := w.inflight[ name ]
+            if pending || inflight {
+                return errBuffered
+            }
+            return condition( current )
+        })
+        if err == errBuffered {
+            continue
+        }
+        if err == nil {
+            w.changes.notify( name )
+        }
+        return err
+    }
+    return ErrConflict
+}
+
+
+func ( w *WriteBehind ) Fetch( ctx context.Context, name string ) ( *Item, error ) {
+    if m, found := w.buffered( name ); found {
+        return m.item, nil
+    }
+    return w.backend.Fetch( ctx, name )
+}
+
+
+func ( w *WriteBehind ) List( ctx context.Context ) ( []string, error ) {
+    names, err := w.backend.List( ctx )
+    if err != nil {
+        return nil, err
+    }
+
+    w.mux.Lock()
+    overlay := make( map[ string ] mutation, len( w.inflight ) + len( w.pending ) )
+    for name, m := range w.inflight {
+        overlay[ name ] = m
+    }
+    for name, m := range w.pending {
+        overlay[ name ] = m
+    }
+    w.mux.Unlock()
+
+    merged := make( []string, 0, len( names ) + len( overlay ) )
+    for _, name := range names {
+        if m, found := overlay[ name ]; found {
+            if m.item != nil {
+                merged = append( merged, name )
+            }
+            delete( overlay, name )
+            continue
+        }
+        merged = append( merged, name )
+    }
+    for name, m := range overlay {
+        if m.item != nil {
+            merged = append( merged, name )
+        }
+    }
+    return merged, nil
+}
+
+
+func ( w *WriteBehind ) Snapshot( ctx context.Context ) ( []Item, error ) {
+    if err := w.Flush(); err != nil {
+        return nil, err
+    }
+    return Snapshot( ctx, w.backend )
+}
+
+
+// Stats reports the entries flushed to the backend
+func ( w *WriteBehind ) Stats( ctx context.Context ) ( Stats, error ) {
+    if counted, ok := w.backend.( Counted ); ok {
+        return counted.Stats( ctx )
+    }
+    return Stats{}, ErrNotCounted
+}
+
+
+func ( w *WriteBehind ) Watch( name string ) ( <-chan struct{}, func() ) {
+    localChanges, stopLocal := w.changes.watch( name )
+    backendChanges, stopBackend := w.backend.Watch( name )
+    changes := make( chan struct{}, 1 )
+    done := make( chan struct{} )
+
+    go func(){
+        defer close( changes )
+        for {
+            select {
+            case _, open := <-localChanges:
+                if !open {
+                    return
+                }
+            case _, open := <-backendChanges:
+                if !open {
+                    return
+                }
+            case <-done:
+                return
+            }
+            signal( changes )
+        }
+    }()
+
+    stopping := sync.Once{}
+    stop := func(){
+        stopping.Do( func(){
+            close( done )
+            stopLocal()
+            stopBackend()
+        })
+    }
+    return changes, stop
+}
+
+
+func ( w *WriteBehind ) observe( observer changeObserver ) func() {
+    if feed, ok := w.backend.( changeFeed ); ok {
+        return feed.observe( observer )
+    }
+    return func(){}
+}
+
+
+func ( w *WriteBehind ) Ping( ctx context.Context ) error {
+    return w.backend.Ping( ctx )
+}
+
+
+func ( w *WriteBehind ) Verify( ctx context.Context ) error {
+    if v, ok := w.backend.( verifier ); ok {
+        return v.Verify( ctx )
+    }
+    return w.backend.Ping( ctx )
+}
+
+
+func ( w *WriteBehind ) Disconnect() error {
+    w.stopping.Do( func(){
+        close( w.stop )
+    })
+    <-w.stopped
+
+    w.mux.Lock()
+    w.disconnected = true
+    w.mux.Unlock()
+    flushErr := w.Flush()
+    if flushErr != nil {
+        log.Error( "Write behind lost buffered entries", "error", flushErr )
+    }
+    w.changes.closeAll()
+
+    return errors.Join( flushErr, w.backend.Disconnect() )
+}
+
+
+func ( w *WriteBehind ) Metrics() []Metric {
+    w.mux.Lock()
+    // a name written again while being flushed is buffered once
+    entries := len( w.pending )
+    for name := range w.inflight {
+        if _, found := w.pending[ name ]; !found {
+            entries++
+        }
+    }
+    bytes := w.pendingBytes
+    w.mux.Unlock()
+
+    metrics := []Metric{
+        {
+            Name: "state_write_behind_buffered_entries",
+            Help: "The current number of acknowledged writes not yet flushed to the backend",
+            Type: "gauge",
+            Value: float64( entries ),
+        },
+        {
+            Name: "state_write_behind_buffered_bytes",
+            Help: "The current size of acknowledged writes not yet flushed to the backend, in bytes",
+            Type: "gauge",
+            Value: float64( bytes ),
+        },
+        {
+            Name: "state_write_behind_buffered_bytes_limit",
+            Help: "The maximal size of acknowledged writes not yet flushed to the backend, in bytes",
+            Type: "gauge",
+            Value: float64( w.maxBytes ),
+        },
+        {
+            Name: "state_write_behind_acknowledged_total",
+            Help: "The number of writes acknowledged from the buffer",
+            Type: "counter",
+            Value: float64( w.acknowledged.Load() ),
+        },
+        {
+            Name: "state_write_behind_flushed_total",
+            Help: "The number of buffered writes flushed to the backend",
+            Type: "counter",
+            Value: float64( w.flushed.Load() ),
+        },
+        {
+            Name: "state_write_behind_flushes_total",
+            Help: "The number of batches flushed to the backend",
+            Type: "counter",
+            Value: float64( w.flushes.Load() ),
+        },
+        {
+            Name: "state_write_behind_flush_errors_total",
+            Help: "The number of batches failing to be flushed to the backend",
+            Type: "counter",
+            Value: float64( w.flushErrors.Load() ),
+        },
+    }
+
+    if backend, ok := w.backend.( Instrumented ); ok {
+        metrics = append( metrics, backend.Metrics()... )
+    }
+    return metrics
+}
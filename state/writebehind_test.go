@@ -0,0 +1,232 @@
+package state
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "testing"
+    "time"
+
+    "webservice/configuration"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func newTestWriteBehind( backend Store, batchSize int, maxBytes int64 ) *WriteBehind {
+    return NewWriteBehindStore( backend, &configuration.Config{
+        WriteBehindBatchSize: batchSize,
+        WriteBehindInterval: time.Hour,
+        WriteBehindMaxBytes: maxBytes,
+    })
+}
+
+
+func TestWriteBehindBuffering( t *testing.T ){
+    backend := NewEphemeralStore()
+    assert.Nil( t, backend.Add( context.Background(), NewItem( "existing", "text/plain", []byte( "old" ) ) ) )
+    assert.Nil( t, backend.Add( context.Background(), NewItem( "removed", "text/plain", []byte( "old" ) ) ) )
+
+    w := newTestWriteBehind( backend, 100, 1024 )
+
+    assert.Nil( t, w.Add( context.Background(), NewItem( "existing", "text/plain", []byte( "new" ) ) ) )
+    assert.Nil( t, w.Add( context.Background(), NewItem( "added", "text/plain", []byte( "new" ) ) ) )
+    assert.Nil( t, w.Remove( context.Background(), "removed" ) )
+
+    fetched, err := w.Fetch( context.Background(), "existing" )
+    assert.Nil( t, err )
+    assert.Equal( t, []byte( "new" ), fetched.Data() )
+    fetched, err = w.Fetch( context.Background(), "removed" )
+    assert.Nil( t, err )
+    assert.Nil( t, fetched )
+
+    names, err := w.List( context.Background() )
+    assert.Nil( t, err )
+    assert.ElementsMatch( t, []string{ "existing", "added" }, names )
+
+    fetched, _ = backend.Fetch( context.Background(), "existing" )
+    assert.Equal( t, []byte( "old" ), fetched.Data() )
+    assert.Equal( t, float64( 3 ), metricValue( w.Metrics(), "state_write_behind_buffered_entries" ) )
+
+    assert.Nil( t, w.Disconnect() )
+    assert.Equal( t, float64( 0 ), metricValue( w.Metrics(), "state_write_behind_buffered_bytes" ) )
+    assert.Equal( t, float64( 3 ), metricValue( w.Metrics(), "state_write_behind_flushed_total" ) )
+}
+
+
+func TestWriteBehindFlushing( t *testing.T ){
+    backend := NewEphemeralStore()
+    w := newTestWriteBehind( backend, 2, 1024 )
+    defer w.Disconnect()
+
+    assert.Nil( t, w.Add( context.Background(), NewItem( "a", "text/plain", []byte( "a" ) ) ) )
+    assert.Nil( t, w.Add( context.Background(), NewItem( "b", "text/plain", []byte( "b" ) ) ) )
+    assert.Eventually( t, func() bool {
+        names, _ := backend.List( context.Background() )
+        return len( names ) == 2
+    }, time.Second, time.Millisecond * 5 )
+
+    limited := newTestWriteBehind( backend, 100, 32 )
+    defer limited.Disconnect()
+
+    assert.Nil( t, limited.Add( context.Background(), NewItem( "c", "text/plain", make( []byte, 16 ) ) ) )
+    assert.Nil( t, limited.Add( context.Background(), NewItem( "d", "text/plain", make( []byte, 16 ) ) ) )
+    assert.LessOrEqual( t, metricValue( limited.Metrics(), "state_write_behind_buffered_bytes" ), float64( 32 ) )
+    fetched, err := backend.Fetch( context.Background(), "c" )
+    assert.Nil( t, err )
+    assert.NotNil( t, fetched )
+
+    assert.Nil( t, limited.Add( context.Background(), NewItem( "e", "text/plain", make( []byte, 64 ) ) ) )
+    fetched, err = backend.Fetch( context.Background(), "e" )
+    assert.Nil( t, err )
+    assert.NotNil( t, fetched )
+}
+
+
+// batchRecorder is a backend recording the size of the batches written
+type batchRecorder struct {
+    *Ephemeral
+
+    mux         sync.Mutex
+    failing     bool
+    batches     []int
+    entered     chan struct{}
+    release     chan struct{}
+}
+
+
+func ( b *batchRecorder ) apply( ctx context.Context, mutations []mutation ) error {
+    b.mux.Lock()
+    b.batches = append( b.batches, len( mutations ) )
+    failing := b.failing
+    b.mux.Unlock()
+
+    if b.entered != nil {
+        b.entered <- struct{}{}
+        <-b.release
+    }
+    if failing {
+        return errors.New( "backend unreachable" )
+    }
+    for _, m := range mutations {
+        if m.item == nil {
+            _ = b.Ephemeral.Remove( ctx, m.name )
+        } else {
+            _ = b.Ephemeral.Add( ctx, *m.item )
+        }
+    }
+    return nil
+}
+
+
+func TestWriteBehindBatches( t *testing.T ){
+    backend := &batchRecorder{ Ephemeral: NewEphemeralStore(), failing: true }
+    w := newTestWriteBehind( backend, 2, 1024 )
+    defer w.Disconnect()
+
+    for i := 0; i < 7; i++ {
+        assert.Nil( t, w.Add( context.Background(), NewItem( fmt.Sprint( i ), "text/plain", []byte( "x" ) ) ) )
+    }
+    assert.NotNil( t, w.Flush() )
+
+    backend.mux.Lock()
+    backend.failing = false
+    backend.mux.Unlock()
+    assert.Nil( t, w.Flush() )
+
+    names, err := backend.List( context.Background() )
+    assert.Nil( t, err )
+    assert.Len( t, names, 7 )
+    backend.mux.Lock()
+    defer backend.mux.Unlock()
+    for _, size := range backend.batches {
+        assert.LessOrEqual( t, size, 2 )
+    }
+    assert.Equal( t, float64( 0 ), metricValue( w.Metrics(), "state_write_behind_buffered_entries" ) )
+    assert.Equal( t, float64( 0 ), metricValue( w.Metrics(), "state_write_behind_buffered_bytes" ) )
+}
+
+
+func TestWriteBehindRewrittenWhileFlushing( t *testing.T ){
+    backend := &batchRecorder{
+        Ephemeral: NewEphemeralStore(),
+        entered: make( chan struct{} ),
+        release: make( chan struct{} ),
+    }
+    w := newTestWriteBehind( backend, 100, 1024 )
+    defer w.Disconnect()
+
+    assert.Nil( t, w.Add( context.Background(), NewItem( "a", "text/plain", []byte( "old" ) ) ) )
+    flushed := make( chan error )
+    go func(){ flushed <- w.Flush() }()
+    <-backend.entered
+
+    assert.Nil( t, w.Add( context.Background(), NewItem( "a", "text/plain", []byte( "new" ) ) ) )
+    assert.Equal( t, float64( 1 ), metricValue( w.Metrics(), "state_write_behind_buffered_entries" ) )
+
+    backend.release <- struct{}{}
+    assert.Nil( t, <-flushed )
+    assert.Equal( t, float64( 1 ), metricValue( w.Metrics(), "state_write_behind_buffered_entries" ) )
+
+    go func(){
+        <-backend.entered
+        backend.release <- struct{}{}
+    }()
+    assert.Nil( t, w.Flush() )
+    fetched, err := backend.Fetch( context.Background(), "a" )
+    assert.Nil( t, err )
+    assert.Equal( t, []byte( "new" ), fetched.Data() )
+}
+
+
+func TestWriteBehindUpdate( t *testing.T ){
+    backend := NewEphemeralStore()
+    w := newTestWriteBehind( backend, 100, 1024 )
+    defer w.Disconnect()
+
+    // a conditional write sees the buffered entry, as it is flushed first
+    assert.Nil( t, w.Add( context.Background(), NewItem( "a", "text/plain", []byte( "buffered" ) ) ) )
+    updated := NewItem( "a", "text/plain", []byte( "updated" ) )
+    assert.Nil( t, w.Update( context.Background(), "a", &updated, func( current *Item ) error {
+        assert.Equal( t, []byte( "buffered" ), current.Data() )
+        return nil
+    }))
+    fetched, err := backend.Fetch( context.Background(), "a" )
+    assert.Nil( t, err )
+    assert.Equal( t, []byte( "updated" ), fetched.Data() )
+    assert.Equal( t, float64( 0 ), metricValue( w.Metrics(), "state_write_behind_buffered_entries" ) )
+
+    rejected := errors.New( "rejected" )
+    assert.Equal( t, rejected, w.Update( context.Background(), "a", nil, func( current *Item ) error {
+        return rejected
+    }))
+    assert.Nil( t, w.Update( context.Background(), "a", nil, nil ) )
+    assert.Equal( t, float64( 1 ), metricValue( w.Metrics(), "state_write_behind_buffered_entries" ) )
+    fetched, err = w.Fetch( context.Background(), "a" )
+    assert.Nil( t, err )
+    assert.Nil( t, fetched )
+}
+
+
+func TestWriteBehindDisconnected( t *testing.T ){
+    w := newTestWriteBehind( NewEphemeralStore(), 100, 8 )
+    assert.Nil( t, w.Add( context.Background(), NewItem( "buffered", "text/plain", []byte( "new" ) ) ) )
+    assert.Nil( t, w.Disconnect() )
+
+    // the buffer is not flushed again, so writes are rejected
+    assert.NotNil( t, w.Add( context.Background(), NewItem( "late", "text/plain", []byte( "new" ) ) ) )
+    assert.NotNil( t, w.Add( context.Background(), NewItem( "large", "text/plain", []byte( "larger than the buffer" ) ) ) )
+    assert.NotNil( t, w.Remove( context.Background(), "buffered" ) )
+    assert.Equal( t, float64( 0 ), metricValue( w.Metrics(), "state_write_behind_buffered_entries" ) )
+}
+
+
+func TestWriteBehindVerify( t *testing.T ){
+    w := newTestWriteBehind( misconfiguredStore{ NewEphemeralStore() }, 100, 1024 )
+    defer w.Disconnect()
+    ctx, cancel := context.WithTimeout( context.Background(), time.Millisecond * 50 )
+    defer cancel()
+    _, err := Await( ctx, w, time.Millisecond * 10 )
+    assert.ErrorContains( t, err, "Database 1 expected to be selected" )
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "mime"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+    fp "path/filepath"
+
+    "webservice/client"
+)
+
+
+const (
+    exitOk          = 0
+    exitFailure     = 1
+    exitUsage       = 2
+    exitNotFound    = 3
+    exitConflict    = 4
+)
+
+
+type stateCommands struct {
+    client *client.Client
+}
+
+
+func exitCode( err error ) int {
+    switch {
+    case errors.Is( err, client.ErrNotFound ):
+        return exitNotFound
+    case errors.Is( err, client.ErrConflict ):
+        return exitConflict
+    default:
+        return exitFailure
+    }
+}
+
+
+func detectMimeType( path string, content []byte ) string {
+    if mimeType := mime.TypeByExtension( fp.Ext( path ) ); len( mimeType ) >= 1 {
+        return mimeType
+    }
+    return http.DetectContentType( content )
+}
+
+
+func ( s *stateCommands ) put( args []string ) error {
+    flags := flag.NewFlagSet( "put", flag.ContinueOnError )
+    mimeType := flags.String( "type", "", "MIME type, detected from the file if not set" )
+    ifMatch := flags.String( "if-match", "", "only write if the current ETag matches" )
+    ifNoneMatch := flags.String( "if-none-match", "", "only write if the current ETag does not match, * to only create" )
+    if err := flags.Parse( args ); err != nil || flags.NArg() != 2 {
+        return flag.ErrHelp
+    }
+    name, path := flags.Arg( 0 ), flags.Arg( 1 )
+
+    var body io.ReadSeeker
+    if path == "-" {
+        content, err := io.ReadAll( os.Stdin )
+        if err != nil {
+            return err
+        }
+        body = bytes.NewReader( content )
+    } else {
+        file, err := os.Open( path )
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        body = file
+    }
+    if len( *mimeType ) <= 0 {
+        head := make( []byte, 512 )
+        n, err := io.ReadFull( body, head )
+        if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+            return err
+        }
+        if _, err := body.Seek( 0, io.SeekStart ); err != nil {
+            return err
+        }
+        *mimeType = detectMimeType( path, head[ :n ] )
+    }
+
+    preconditions := []client.Precondition{}
+    if len( *ifMatch ) >= 1 {
+        preconditions = append( preconditions, client.IfMatch( *ifMatch ) )
+    }
+    if len( *ifNoneMatch ) >= 1 {
+        preconditions = append( preconditions, client.IfNoneMatch( *ifNoneMatch ) )
+    }
+
+    etag, err := s.client.Put( context.Background(), name, *mimeType, body, preconditions... )
+    if err != nil {
+        return err
+    }
+    fmt.Println( etag )
+    return nil
+}
+
+
+func ( s *stateCommands ) get( args []string ) error {
+    flags := flag.NewFlagSet( "get", flag.ContinueOnError )
+    output := flags.String( "output", "-", "file to write the entry to" )
+    if err := flags.Parse( args ); err != nil || flags.NArg() != 1 {
+        return flag.ErrHelp
+    }
+
+    entry, err := s.client.Get( context.Background(), flags.Arg( 0 ) )
+    if err != nil {
+        return err
+    }
+    defer entry.Body.Close()
+
+    target := io.Writer( os.Stdout )
+    if *output != "-" {
+        file, err := os.Create( *output )
+        if err != nil {
+            return err
+        }
+        defer file.Close()
+        target = file
+    }
+    _, err = io.Copy( target, entry.Body )
+    return err
+}
+
+
+func ( s *stateCommands ) head( args []string ) error {
+    if len( args ) != 1 {
+        return flag.ErrHelp
+    }
+
+    info, err := s.client.Head( context.Background(), args[ 0 ] )
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf( "Content-Type: %s\n", info.MimeType )
+    fmt.Printf( "Content-Length: %d\n", info.Size )
+    fmt.Printf( "ETag: %s\n", info.ETag )
+    return nil
+}
+
+
+func ( s *stateCommands ) remove( args []string ) error {
+    flags := flag.NewFlagSet( "rm", flag.ContinueOnError )
+    ifMatch := flags.String( "if-match", "", "only remove if the current ETag matches" )
+    if err := flags.Parse( args ); err != nil || flags.NArg() != 1 {
+        return flag.ErrHelp
+    }
+
+    preconditions := []client.Precondition{}
+    if len( *ifMatch ) >= 1 {
+        preconditions = append( preconditions, client.IfMatch( *ifMatch ) )
+    }
+    return s.client.Remove( context.Background(), flags.Arg( 0 ), preconditions... )
+}
+
+
+func ( s *stateCommands ) list( args []string ) error {
+    flags := flag.NewFlagSet( "ls", flag.ContinueOnError )
+    asJson := flags.Bool( "json", false, "print a JSON array" )
+    if err := flags.Parse( args ); err != nil || flags.NArg() != 0 {
+        return flag.ErrHelp
+    }
+
+    names, err := s.client.List( context.Background() )
+    if err != nil {
+        return err
+    }
+
+    if *asJson {
+        return json.NewEncoder( os.Stdout ).Encode( names )
+    }
+    for _, name := range names {
+        fmt.Println( name )
+    }
+    return nil
+}
+
+
+func ( s *stateCommands ) watch( args []string ) error {
+    flags := flag.NewFlagSet( "watch", flag.ContinueOnError )
+    wait := flags.Duration( "wait", time.Second * 30, "how long a single request waits for changes" )
+    asJson := flags.Bool( "json", false, "print changes as JSON lines" )
+    if err := flags.Parse( args ); err != nil || flags.NArg() != 1 {
+        return flag.ErrHelp
+    }
+    name := flags.Arg( 0 )
+
+    after := ""
+    for {
+        entry, changed, err := s.client.Wait( context.Background(), name, after, *wait )
+
+        event := map[ string ] any { "name": name }
+        switch {
+        case errors.Is( err, client.ErrNotFound ):
+            if len( after ) <= 0 {
+                continue
+            }
+            after = ""
+            event[ "event" ] = "removed"
+        case err != nil:
+            return err
+        case !changed:
+            continue
+        default:
+            entry.Body.Close()
+            after = entry.ETag
+            event[ "event" ] = "changed"
+            event[ "etag" ] = entry.ETag
+            event[ "contentType" ] = entry.MimeType
+            event[ "contentLength" ] = entry.Size
+        }
+
+        if *asJson {
+            if err := json.NewEncoder( os.Stdout ).Encode( event ); err != nil {
+                return err
+            }
+        } else if event[ "event" ] == "removed" {
+            fmt.Printf( "removed %s\n", name )
+        } else {
+            fmt.Printf(
+                "changed %s %s %s %d\n",
+                name, event[ "etag" ], event[ "contentType" ], event[ "contentLength" ],
+            )
+        }
+    }
+}
+
+
+func runState( args []string ) int {
+    flags := flag.NewFlagSet( "state", flag.ContinueOnError )
+    flags.Usage = func(){
+        fmt.Fprintln( flags.Output(), "Usage: artifact.bin state [options] <command> [arguments]" )
+        fmt.Fprintln( flags.Output(), "" )
+        fmt.Fprintln( flags.Output(), "Commands:" )
+        fmt.Fprintln( flags.Output(), "  put [--type mime] [--if-match etag] [--if-none-match etag] <name> <file|->" )
+        fmt.Fprintln( flags.Output(), "  get [--output file] <name>" )
+        fmt.Fprintln( flags.Output(), "  head <name>" )
+        fmt.Fprintln( flags.Output(), "  rm [--if-match etag] <name>" )
+        fmt.Fprintln( flags.Output(), "  ls [--json]" )
+        fmt.Fprintln( flags.Output(), "  watch [--wait duration] [--json] <name>" )
+        fmt.Fprintln( flags.Output(), "" )
+        fmt.Fprintln( flags.Output(), "Exit codes: 0 success, 1 failure, 2 usage, 3 not found, 4 conflict" )
+        fmt.Fprintln( flags.Output(), "" )
+        fmt.Fprintln( flags.Output(), "Options:" )
+        flags.PrintDefaults()
+    }
+
+    server := os.Getenv( "WEBSERVICE_URL" )
+    if len( server ) <= 0 {
+        server = "http://127.0.0.1:3000"
+    }
+    flags.StringVar( &server, "server", server, "server URL, defaults to WEBSERVICE_URL" )
+    tokenFile := flags.String( "token-file", os.Getenv( "WEBSERVICE_TOKEN_FILE" ), "file containing a bearer token, defaults to WEBSERVICE_TOKEN_FILE" )
+    timeout := flags.Duration( "timeout", time.Second * 30, "timeout of a single request" )
+
+    if err := flags.Parse( args ); err != nil {
+        return exitUsage
+    }
+    if flags.NArg() < 1 {
+        flags.Usage()
+        return exitUsage
+    }
+
+    options := []client.Option{ client.WithTimeout( *timeout ) }
+    if len( *tokenFile ) >= 1 {
+        content, err := os.ReadFile( *tokenFile )
+        if err != nil {
+            fmt.Fprintf( os.Stderr, "Token not able to be read: %v\n", err )
+            return exitFailure
+        }
+        options = append( options, client.WithToken( strings.TrimSpace( string( content ) ) ) )
+    }
+    c, err := client.New( server, options... )
+    if err != nil {
+        fmt.Fprintln( os.Stderr, err )
+        return exitUsage
+    }
+
+    s := &stateCommands{ client: c }
+    commands := map[ string ] func( []string ) error {
+        "put":      s.put,
+        "get":      s.get,
+        "head":     s.head,
+        "rm":       s.remove,
+        "ls":       s.list,
+        "watch":    s.watch,
+    }
+    command, found := commands[ flags.Arg( 0 ) ]
+    if !found {
+        flags.Usage()
+        return exitUsage
+    }
+
+    err = command( flags.Args()[ 1: ] )
+    if errors.Is( err, flag.ErrHelp ) {
+        flags.Usage()
+        return exitUsage
+    }
+    if err != nil {
+        fmt.Fprintln( os.Stderr, err )
+        return exitCode( err )
+    }
+    return exitOk
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    ht "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    fp "path/filepath"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+// stateServer stands in for the state API, answering like the service does
+func stateServer( t *testing.T, token string ) *ht.Server {
+    mux := sync.Mutex{}
+    entries := map[ string ] string {}
+    versions := map[ string ] int {}
+
+    server := ht.NewServer( http.HandlerFunc( func( w http.ResponseWriter, req *http.Request ){
+        mux.Lock()
+        defer mux.Unlock()
+
+        if req.Header.Get( "Authorization" ) != "Bearer " + token {
+            w.WriteHeader( http.StatusUnauthorized )
+            return
+        }
+        if req.URL.Path == "/states" {
+            paths := []string{}
+            for name := range entries {
+                paths = append( paths, "/state/" + name )
+            }
+            _ = json.NewEncoder( w ).Encode( paths )
+            return
+        }
+
+        name := strings.TrimPrefix( req.URL.Path, "/state/" )
+        data, found := entries[ name ]
+        etag := fmt.Sprintf( `"%d"`, versions[ name ] )
+        if name == "locked" {
+            w.WriteHeader( http.StatusConflict )
+            return
+        }
+        if found && req.Header.Get( "If-None-Match" ) == "*" ||
+           len( req.Header.Get( "If-Match" ) ) >= 1 && req.Header.Get( "If-Match" ) != etag {
+            w.WriteHeader( http.StatusPreconditionFailed )
+            return
+        }
+
+        switch req.Method {
+        case http.MethodPut:
+            content, _ := io.ReadAll( req.Body )
+            entries[ name ] = req.Header.Get( "Content-Type" ) + ";" + string( content )
+            versions[ name ]++
+            w.Header().Set( "ETag", fmt.Sprintf( `"%d"`, versions[ name ] ) )
+            w.WriteHeader( http.StatusCreated )
+        case http.MethodGet, http.MethodHead:
+            if !found {
+                w.WriteHeader( http.StatusNotFound )
+                return
+            }
+            mimeType, content, _ := strings.Cut( data, ";" )
+            w.Header().Set( "Content-Type", mimeType )
+            w.Header().Set( "ETag", etag )
+            _, _ = io.WriteString( w, content )
+        case http.MethodDelete:
+            if !found {
+                w.WriteHeader( http.StatusNotFound )
+                return
+            }
+            delete( entries, name )
+            w.WriteHeader( http.StatusNoContent )
+        }
+    }))
+    t.Cleanup( server.Close )
+    return server
+}
+
+
+// runStateCommand runs the state subcommand and returns its exit code and
+// what it printed
+func runStateCommand( t *testing.T, args ...string ) ( int, string ) {
+    output, err := os.CreateTemp( t.TempDir(), "stdout" )
+    assert.Nil( t, err )
+    defer output.Close()
+
+    stdout, stderr := os.Stdout, os.Stderr
+    os.Stdout, os.Stderr = output, output
+    code := runState( args )
+    os.Stdout, os.Stderr = stdout, stderr
+
+    printed, err := os.ReadFile( output.Name() )
+    assert.Nil( t, err )
+    return code, string( printed )
+}
+
+
+func TestStateUsage( t *testing.T ){
+    server := stateServer( t, "" )
+    for _, args := range [][]string{
+        {},
+        { "unknown" },
+        { "--unknown", "ls" },
+        { "--server", "ftp://localhost", "ls" },
+        { "put", "name" },
+        { "put", "--type" },
+        { "get" },
+        { "get", "a", "b" },
+        { "head" },
+        { "rm" },
+        { "ls", "extra" },
+        { "watch" },
+    }{
+        code, printed := runStateCommand( t, append( []string{ "--server", server.URL }, args... )... )
+        assert.Equal( t, exitUsage, code, args )
+        if len( args ) >= 1 && args[ 0 ] != "--server" {
+            assert.Contains( t, printed, "Usage:", args )
+        }
+    }
+}
+
+
+func TestStateExitCodes( t *testing.T ){
+    tokenFile := fp.Join( t.TempDir(), "token" )
+    assert.Nil( t, os.WriteFile( tokenFile, []byte( "secret\n" ), 0600 ) )
+    t.Setenv( "WEBSERVICE_URL", stateServer( t, "secret" ).URL )
+    t.Setenv( "WEBSERVICE_TOKEN_FILE", tokenFile )
+
+    file := fp.Join( t.TempDir(), "entry.json" )
+    assert.Nil( t, os.WriteFile( file, []byte( `{ "key": "value" }` ), 0600 ) )
+
+    code, printed := runStateCommand( t, "put", "entry", file )
+    assert.Equal( t, exitOk, code )
+    assert.Equal( t, "\"1\"\n", printed )
+
+    code, printed = runStateCommand( t, "head", "entry" )
+    assert.Equal( t, exitOk, code )
+    assert.Contains( t, printed, "Content-Type: application/json\n" )
+    assert.Contains( t, printed, "ETag: \"1\"\n" )
+
+    code, printed = runStateCommand( t, "get", "entry" )
+    assert.Equal( t, exitOk, code )
+    assert.Equal( t, `{ "key": "value" }`, printed )
+
+    code, printed = runStateCommand( t, "ls", "--json" )
+    assert.Equal( t, exitOk, code )
+    assert.Equal( t, "[\"entry\"]\n", printed )
+
+    code, _ = runStateCommand( t, "put", "--if-none-match", "*", "entry", file )
+    assert.Equal( t, exitConflict, code )
+    code, _ = runStateCommand( t, "rm", "--if-match", `"0"`, "entry" )
+    assert.Equal( t, exitConflict, code )
+    code, _ = runStateCommand( t, "put", "locked", file )
+    assert.Equal( t, exitConflict, code )
+
+    code, _ = runStateCommand( t, "rm", "--if-match", `"1"`, "entry" )
+    assert.Equal( t, exitOk, code )
+    code, _ = runStateCommand( t, "get", "entry" )
+    assert.Equal( t, exitNotFound, code )
+    code, _ = runStateCommand( t, "rm", "entry" )
+    assert.Equal( t, exitNotFound, code )
+
+    code, _ = runStateCommand( t, "put", "entry", fp.Join( t.TempDir(), "missing" ) )
+    assert.Equal( t, exitFailure, code )
+    code, printed = runStateCommand( t, "--token-file", fp.Join( t.TempDir(), "missing" ), "ls" )
+    assert.Equal( t, exitFailure, code )
+    assert.Contains( t, printed, "Token not able to be read" )
+
+    t.Setenv( "WEBSERVICE_TOKEN_FILE", "" )
+    code, printed = runStateCommand( t, "ls" )
+    assert.Equal( t, exitFailure, code )
+    assert.Contains( t, printed, "401" )
+}
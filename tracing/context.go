@@ -0,0 +1,146 @@
+package tracing
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+    "regexp"
+)
+
+
+// Trace context propagation, see https://www.w3.org/TR/trace-context/
+
+
+const (
+    TraceparentHeader = "traceparent"
+    TracestateHeader = "tracestate"
+
+    // TraceresponseHeader tells the caller the trace and span of the server,
+    // see https://www.w3.org/TR/trace-context-2/#traceresponse-header
+    TraceresponseHeader = "traceresponse"
+
+    // tracestate is dropped rather than truncated beyond this length, as
+    // truncating requires to know which members vendors can do without
+    maxTracestateLength = 512
+)
+
+
+var traceparentPattern = regexp.MustCompile(
+    `^([0-9a-f]{2})-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})(-[^ ]*)?$`,
+)
+
+
+type TraceId [ 16 ]byte
+
+func ( id TraceId ) String() string {
+    return hex.EncodeToString( id[ : ] )
+}
+
+
+type SpanId [ 8 ]byte
+
+func ( id SpanId ) String() string {
+    return hex.EncodeToString( id[ : ] )
+}
+
+
+type SpanContext struct {
+    TraceId     TraceId
+    SpanId      SpanId
+    Sampled     bool
+
+    // State is the tracestate, passed on as received
+    State       string
+}
+
+
+func ( s SpanContext ) IsValid() bool {
+    return s.TraceId != TraceId{} && s.SpanId != SpanId{}
+}
+
+
+// Traceparent formats the context as the traceparent header does, which the
+// traceresponse header shares
+func ( s SpanContext ) Traceparent() string {
+    flags := 0
+    if s.Sampled {
+        flags = 1
+    }
+    return fmt.Sprintf( "00-%s-%s-%02x", s.TraceId, s.SpanId, flags )
+}
+
+
+// Extract parses the trace context headers of an incoming request, the
+// returned context is not valid if there is none or it is malformed
+func Extract( traceparent string, tracestate string ) SpanContext {
+    matches := traceparentPattern.FindStringSubmatch( traceparent )
+    if matches == nil {
+        return SpanContext{}
+    }
+    // version 00 has no further fields, later versions may add some
+    version, suffix := matches[ 1 ], matches[ 5 ]
+    if version == "ff" || ( version == "00" && len( suffix ) >= 1 ) {
+        return SpanContext{}
+    }
+
+    s := SpanContext{}
+    _, _ = hex.Decode( s.TraceId[ : ], []byte( matches[ 2 ] ) )
+    _, _ = hex.Decode( s.SpanId[ : ], []byte( matches[ 3 ] ) )
+    flags, _ := hex.DecodeString( matches[ 4 ] )
+    s.Sampled = flags[ 0 ] & 1 == 1
+    if !s.IsValid() {
+        return SpanContext{}
+    }
+    if len( tracestate ) <= maxTracestateLength {
+        s.State = tracestate
+    }
+    return s
+}
+
+
+type spanKey struct{}
+
+
+func withSpan( ctx context.Context, span *Span ) context.Context {
+    return context.WithValue( ctx, spanKey{}, span )
+}
+
+
+// FromContext returns the span ctx belongs to, nil if there is none
+func FromContext( ctx context.Context ) *Span {
+    span, _ := ctx.Value( spanKey{} ).( *Span )
+    return span
+}
+
+
+func newTraceId() TraceId {
+    id := TraceId{}
+    for id == ( TraceId{} ) {
+        _, _ = rand.Read( id[ : ] )
+    }
+    return id
+}
+
+
+func newSpanId() SpanId {
+    id := SpanId{}
+    for id == ( SpanId{} ) {
+        _, _ = rand.Read( id[ : ] )
+    }
+    return id
+}
+
+
+// sampled decides on the trace id alone, so that every service sampling
+// with the same ratio keeps the same traces
+func sampled( id TraceId, ratio float64 ) bool {
+    if ratio >= 1 {
+        return true
+    }
+    if ratio <= 0 {
+        return false
+    }
+    return binary.BigEndian.Uint64( id[ 8: ] ) >> 11 < uint64( ratio * ( 1 << 53 ) )
+}
@@ -0,0 +1,129 @@
+package tracing
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "webservice/otlp"
+)
+
+
+type Kind int
+
+const (
+    KindInternal Kind = 1
+    KindServer Kind = 2
+    KindClient Kind = 3
+)
+
+
+// Span times an operation, all its methods may be called on nil, which is
+// what Start returns outside of a trace
+type Span struct {
+    tracer      *Tracer
+    context     SpanContext
+    parent      SpanId
+    kind        Kind
+    started     time.Time
+
+    mux         sync.Mutex
+    name        string
+    attributes  []otlp.Attribute
+    err         error
+    ended       time.Time
+}
+
+
+// Start begins a span as a child of the span in ctx, there is none if ctx
+// is not part of a trace or its spans are not exported
+func Start( ctx context.Context, name string, kind Kind, attributes ...otlp.Attribute ) ( context.Context, *Span ) {
+    parent := FromContext( ctx )
+    if parent == nil || !parent.tracer.exporting() {
+        return ctx, nil
+    }
+    span := &Span{
+        tracer: parent.tracer,
+        context: SpanContext{
+            TraceId: parent.context.TraceId,
+            SpanId: newSpanId(),
+            Sampled: parent.context.Sampled,
+            State: parent.context.State,
+        },
+        parent: parent.context.SpanId,
+        kind: kind,
+        started: time.Now(),
+        name: name,
+        attributes: attributes,
+    }
+    return withSpan( ctx, span ), span
+}
+
+
+func ( s *Span ) Context() SpanContext {
+    if s == nil {
+        return SpanContext{}
+    }
+    return s.context
+}
+
+
+// SetName renames the span, for when a better name is only known once the
+// operation progressed
+func ( s *Span ) SetName( name string ) {
+    if s == nil {
+        return
+    }
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    s.name = name
+}
+
+
+func ( s *Span ) SetAttributes( attributes ...otlp.Attribute ) {
+    if s == nil || !s.recording() {
+        return
+    }
+    s.mux.Lock()
+    defer s.mux.Unlock()
+    s.attributes = append( s.attributes, attributes... )
+}
+
+
+// End concludes the span, err marks it as failed
+func ( s *Span ) End( err error ) {
+    if s == nil {
+        return
+    }
+    s.mux.Lock()
+    if !s.ended.IsZero() {
+        s.mux.Unlock()
+        return
+    }
+    s.ended = time.Now()
+    s.err = err
+    s.mux.Unlock()
+
+    if s.recording() {
+        s.tracer.enqueue( s )
+    }
+}
+
+
+func ( s *Span ) recording() bool {
+    return s.context.Sampled && s.tracer.exporting()
+}
+
+
+// StoreObserver starts a span for every store operation, to be passed to
+// state.NewObservedStore
+func StoreObserver( backend string ) func( context.Context, string ) ( context.Context, func( error ) ) {
+    return func( ctx context.Context, operation string ) ( context.Context, func( error ) ) {
+        ctx, span := Start(
+            ctx, "state." + operation, KindInternal,
+            otlp.String( "state.backend", backend ),
+            otlp.String( "state.operation", operation ),
+        )
+        return ctx, span.End
+    }
+}
@@ -0,0 +1,246 @@
+package tracing
+
+import (
+    "context"
+    "sync"
+    "time"
+    log "log/slog"
+
+    "webservice/configuration"
+    "webservice/otlp"
+)
+
+
+const (
+    exportInterval = time.Second * 5
+    batchSize = 512
+
+    // spans beyond are dropped while the collector does not keep up
+    maxQueued = 8192
+
+    statusError = 2
+)
+
+
+type otlpTraces struct {
+    ResourceSpans   []otlpResourceSpans `json:"resourceSpans"`
+}
+
+
+type otlpResourceSpans struct {
+    Resource    otlp.Resource       `json:"resource"`
+    ScopeSpans  []otlpScopeSpans    `json:"scopeSpans"`
+}
+
+
+type otlpScopeSpans struct {
+    Scope       otlp.Scope  `json:"scope"`
+    Spans       []otlpSpan  `json:"spans"`
+}
+
+
+// otlpSpan follows the JSON encoding of OTLP, in which ids are hex encoded
+type otlpSpan struct {
+    TraceId             string              `json:"traceId"`
+    SpanId              string              `json:"spanId"`
+    TraceState          string              `json:"traceState,omitempty"`
+    ParentSpanId        string              `json:"parentSpanId,omitempty"`
+    Name                string              `json:"name"`
+    Kind                Kind                `json:"kind"`
+    StartTimeUnixNano   string              `json:"startTimeUnixNano"`
+    EndTimeUnixNano     string              `json:"endTimeUnixNano"`
+    Attributes          []otlp.Attribute    `json:"attributes,omitempty"`
+    Status              otlpStatus          `json:"status"`
+}
+
+
+type otlpStatus struct {
+    Code        int     `json:"code,omitempty"`
+    Message     string  `json:"message,omitempty"`
+}
+
+
+// Tracer starts the traces of requests and exports the sampled spans to an
+// OTLP/HTTP collector, if one is configured
+type Tracer struct {
+    ratio       float64
+    resource    otlp.Resource
+    client      *otlp.Client
+
+    mux         sync.Mutex
+    queue       []*Span
+    dropped     int
+
+    full        chan struct{}
+    stop        chan struct{}
+    stopped     chan struct{}
+    stopping    sync.Once
+}
+
+
+func NewTracer( c *configuration.Config ) *Tracer {
+    t := &Tracer{
+        ratio: c.TracingSampleRatio,
+        resource: otlp.ResourceOf( c ),
+        full: make( chan struct{}, 1 ),
+        stop: make( chan struct{} ),
+        stopped: make( chan struct{} ),
+    }
+    if len( c.OTLPEndpoint ) >= 1 {
+        t.client = otlp.NewClient( c )
+    }
+    return t
+}
+
+
+// Trace begins the span of a request, continuing the trace of the caller
+// if parent is valid and starting a trace otherwise; without a collector
+// the span does not record and merely carries the context of the caller
+func ( t *Tracer ) Trace(
+    ctx context.Context,
+    parent SpanContext,
+    name string,
+    kind Kind,
+    attributes ...otlp.Attribute,
+) ( context.Context, *Span ) {
+    if !t.exporting() {
+        if !parent.IsValid() {
+            return ctx, nil
+        }
+        span := &Span{ context: parent }
+        return withSpan( ctx, span ), span
+    }
+
+    span := &Span{
+        tracer: t,
+        context: parent,
+        kind: kind,
+        started: time.Now(),
+        name: name,
+        attributes: attributes,
+    }
+    if parent.IsValid() {
+        span.parent = parent.SpanId
+    } else {
+        span.context.TraceId = newTraceId()
+        span.context.Sampled = sampled( span.context.TraceId, t.ratio )
+    }
+    span.context.SpanId = newSpanId()
+    return withSpan( ctx, span ), span
+}
+
+
+func ( t *Tracer ) exporting() bool {
+    return t != nil && t.client != nil
+}
+
+
+func ( t *Tracer ) enqueue( span *Span ) {
+    t.mux.Lock()
+    defer t.mux.Unlock()
+    if len( t.queue ) >= maxQueued {
+        t.dropped++
+        return
+    }
+    t.queue = append( t.queue, span )
+    if len( t.queue ) >= batchSize {
+        select {
+        case t.full <- struct{}{}:
+        default:
+        }
+    }
+}
+
+
+func ( t *Tracer ) Start() {
+    if !t.exporting() {
+        close( t.stopped )
+        return
+    }
+    go func(){
+        defer close( t.stopped )
+        ticker := time.NewTicker( exportInterval )
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                t.export()
+            case <-t.full:
+                t.export()
+            case <-t.stop:
+                return
+            }
+        }
+    }()
+}
+
+
+// Stop exports the spans still queued
+func ( t *Tracer ) Stop() {
+    t.stopping.Do( func(){
+        close( t.stop )
+        <-t.stopped
+        if t.exporting() {
+            t.export()
+        }
+    })
+}
+
+
+func ( t *Tracer ) export() {
+    for {
+        t.mux.Lock()
+        if t.dropped >= 1 {
+            log.Warn( "Spans dropped, as the collector does not keep up", "spans", t.dropped )
+            t.dropped = 0
+        }
+        spans := t.queue[ :min( len( t.queue ), batchSize ) ]
+        t.queue = t.queue[ len( spans ): ]
+        t.mux.Unlock()
+
+        if len( spans ) <= 0 {
+            return
+        }
+        if err := t.Export( context.Background(), spans ); err != nil {
+            log.Error( "Traces export failed", "endpoint", t.client.Endpoint(), "error", err )
+            return
+        }
+    }
+}
+
+
+// Export pushes ended spans to the collector
+func ( t *Tracer ) Export( ctx context.Context, spans []*Span ) error {
+    exported := make( []otlpSpan, 0, len( spans ) )
+    for _, span := range spans {
+        span.mux.Lock()
+        s := otlpSpan{
+            TraceId: span.context.TraceId.String(),
+            SpanId: span.context.SpanId.String(),
+            TraceState: span.context.State,
+            Name: span.name,
+            Kind: span.kind,
+            StartTimeUnixNano: otlp.UnixNano( span.started ),
+            EndTimeUnixNano: otlp.UnixNano( span.ended ),
+            Attributes: span.attributes,
+        }
+        if span.parent != ( SpanId{} ) {
+            s.ParentSpanId = span.parent.String()
+        }
+        if span.err != nil {
+            s.Status = otlpStatus{ Code: statusError, Message: span.err.Error() }
+        }
+        span.mux.Unlock()
+        exported = append( exported, s )
+    }
+
+    return t.client.Export( ctx, "traces", otlpTraces{
+        ResourceSpans: []otlpResourceSpans{{
+            Resource: t.resource,
+            ScopeSpans: []otlpScopeSpans{{
+                Scope: otlp.Scope{ Name: "webservice" },
+                Spans: exported,
+            }},
+        }},
+    })
+}
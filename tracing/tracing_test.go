@@ -0,0 +1,196 @@
+package tracing
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    ht "net/http/httptest"
+    "strings"
+    "testing"
+
+    "webservice/configuration"
+    "webservice/otlp"
+
+    "github.com/stretchr/testify/assert"
+)
+
+
+func TestExtract( t *testing.T ){
+    s := Extract( "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "vendor=value" )
+    assert.True( t, s.IsValid() )
+    assert.True( t, s.Sampled )
+    assert.Equal( t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceId.String() )
+    assert.Equal( t, "00f067aa0ba902b7", s.SpanId.String() )
+    assert.Equal( t, "vendor=value", s.State )
+    assert.Equal( t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", s.Traceparent() )
+
+    s = Extract( "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-future", strings.Repeat( "a", 513 ) )
+    assert.True( t, s.IsValid() )
+    assert.False( t, s.Sampled )
+    assert.Equal( t, "", s.State )
+
+    for _, invalid := range []string{
+        "",
+        "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+        "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+        "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+        "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+        "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+        "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+    }{
+        assert.False( t, Extract( invalid, "" ).IsValid(), invalid )
+    }
+}
+
+
+func TestSampled( t *testing.T ){
+    kept := 0
+    for i := 0; i < 1000; i++ {
+        id := newTraceId()
+        assert.True( t, sampled( id, 1 ) )
+        assert.False( t, sampled( id, 0 ) )
+        if sampled( id, 0.5 ) {
+            kept++
+        }
+    }
+    assert.InDelta( t, 500, kept, 100 )
+}
+
+
+type exportedSpan struct {
+    TraceId         string  `json:"traceId"`
+    SpanId          string  `json:"spanId"`
+    ParentSpanId    string  `json:"parentSpanId"`
+    TraceState      string  `json:"traceState"`
+    Name            string  `json:"name"`
+    Kind            int     `json:"kind"`
+    Attributes      []struct {
+        Key     string              `json:"key"`
+        Value   map[ string ] any   `json:"value"`
+    } `json:"attributes"`
+    Status          struct {
+        Code    int     `json:"code"`
+        Message string  `json:"message"`
+    } `json:"status"`
+}
+
+
+// collector stands in for an OpenTelemetry collector receiving OTLP/HTTP
+func collector( t *testing.T ) ( *ht.Server, func() []exportedSpan ) {
+    received := make( chan []exportedSpan, 16 )
+    server := ht.NewServer( http.HandlerFunc( func( w http.ResponseWriter, req *http.Request ){
+        assert.Equal( t, "/v1/traces", req.URL.Path )
+        assert.Equal( t, "application/json", req.Header.Get( "Content-Type" ) )
+        body, err := io.ReadAll( req.Body )
+        assert.Nil( t, err )
+        exported := struct {
+            ResourceSpans []struct {
+                ScopeSpans []struct {
+                    Spans []exportedSpan `json:"spans"`
+                } `json:"scopeSpans"`
+            } `json:"resourceSpans"`
+        }{}
+        assert.Nil( t, json.Unmarshal( body, &exported ) )
+        assert.Contains( t, string( body ), `{"key":"service.name","value":{"stringValue":"webservice"}}` )
+        received <- exported.ResourceSpans[ 0 ].ScopeSpans[ 0 ].Spans
+    }))
+    t.Cleanup( server.Close )
+
+    return server, func() []exportedSpan {
+        spans := []exportedSpan{}
+        for len( received ) >= 1 {
+            spans = append( spans, <-received... )
+        }
+        return spans
+    }
+}
+
+
+func newTestTracer( t *testing.T, endpoint string ) *Tracer {
+    t.Setenv( "ENV_NAME", "testing" )
+    t.Setenv( "OTLP_ENDPOINT", endpoint )
+    config, err := configuration.New()
+    assert.Nil( t, err )
+    return NewTracer( config )
+}
+
+
+func TestTracer( t *testing.T ){
+    server, received := collector( t )
+    tracer := newTestTracer( t, server.URL )
+    tracer.Start()
+
+    ctx, span := Start( context.Background(), "orphan", KindInternal )
+    assert.Nil( t, span )
+    assert.Nil( t, FromContext( ctx ) )
+    span.SetAttributes( otlp.Bool( "ignored", true ) )
+    span.End( nil )
+
+    parent := Extract( "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "vendor=value" )
+    ctx, root := tracer.Trace( context.Background(), parent, "GET", KindServer )
+    assert.Equal( t, parent.TraceId, root.Context().TraceId )
+    assert.NotEqual( t, parent.SpanId, root.Context().SpanId )
+    _, child := Start( ctx, "state.fetch", KindInternal, otlp.String( "state.backend", "ephemeral" ) )
+    assert.Equal( t, parent.TraceId, child.Context().TraceId )
+    child.End( errors.New( "backend unreachable" ) )
+    root.SetName( "GET /state/:name" )
+    root.End( nil )
+    root.End( errors.New( "ended twice" ) )
+
+    _, unsampled := tracer.Trace(
+        context.Background(),
+        Extract( "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", "" ),
+        "GET", KindServer,
+    )
+    unsampled.End( nil )
+
+    tracer.Stop()
+    spans := received()
+    assert.Equal( t, 2, len( spans ) )
+
+    assert.Equal( t, "state.fetch", spans[ 0 ].Name )
+    assert.Equal( t, int( KindInternal ), spans[ 0 ].Kind )
+    assert.Equal( t, root.Context().SpanId.String(), spans[ 0 ].ParentSpanId )
+    assert.Equal( t, 2, spans[ 0 ].Status.Code )
+    assert.Equal( t, "backend unreachable", spans[ 0 ].Status.Message )
+    assert.Equal( t, "state.backend", spans[ 0 ].Attributes[ 0 ].Key )
+
+    assert.Equal( t, "GET /state/:name", spans[ 1 ].Name )
+    assert.Equal( t, int( KindServer ), spans[ 1 ].Kind )
+    assert.Equal( t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[ 1 ].TraceId )
+    assert.Equal( t, "00f067aa0ba902b7", spans[ 1 ].ParentSpanId )
+    assert.Equal( t, "vendor=value", spans[ 1 ].TraceState )
+    assert.Equal( t, 0, spans[ 1 ].Status.Code )
+}
+
+
+func TestTracerWithoutCollector( t *testing.T ){
+    tracer := newTestTracer( t, "" )
+    tracer.Start()
+    ctx, root := tracer.Trace( context.Background(), SpanContext{}, "GET", KindServer )
+    assert.Nil( t, root )
+    assert.Nil( t, FromContext( ctx ) )
+    assert.False( t, root.Context().IsValid() )
+    assert.Equal( t, float64( 0 ), testing.AllocsPerRun( 100, func(){
+        _, span := tracer.Trace( context.Background(), SpanContext{}, "GET", KindServer )
+        span.End( nil )
+    }))
+
+    // the trace of the caller is still known, to be logged
+    parent := Extract( "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "" )
+    ctx, root = tracer.Trace( context.Background(), parent, "GET", KindServer )
+    assert.Equal( t, parent, root.Context() )
+    assert.Equal( t, root, FromContext( ctx ) )
+    childCtx, child := Start( ctx, "state.list", KindInternal )
+    assert.Nil( t, child )
+    assert.Equal( t, ctx, childCtx )
+    child.End( nil )
+    root.SetName( "GET /states" )
+    root.SetAttributes( otlp.Bool( "ignored", true ) )
+    root.End( nil )
+    tracer.Stop()
+    assert.Equal( t, 0, len( tracer.queue ) )
+    assert.Empty( t, root.attributes )
+}